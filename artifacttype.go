@@ -0,0 +1,90 @@
+package main
+
+// artifactTypeAliases maps readable names to the artifact or config media types they stand for
+var artifactTypeAliases = map[string][]string{
+	"image": {
+		"application/vnd.docker.container.image.v1+json",
+		"application/vnd.oci.image.config.v1+json",
+	},
+	"chart": {helmConfigMediaType},
+	"sbom": {
+		"application/spdx+json",
+		"text/spdx",
+		"application/vnd.cyclonedx+json",
+		"application/vnd.syft+json",
+	},
+	"signature": {
+		"application/vnd.dev.cosign.artifact.sig.v1+json",
+		"application/vnd.cncf.notary.signature",
+	},
+	"attestation": {
+		"application/vnd.in-toto+json",
+		"application/vnd.dsse.envelope.v1+json",
+	},
+}
+
+type artifactTypes []string
+
+// Set adds an alias (image, chart, sbom, signature, attestation) or a media type
+func (a *artifactTypes) Set(value string) error {
+	if types, ok := artifactTypeAliases[value]; ok {
+		*a = append(*a, types...)
+	} else {
+		*a = append(*a, value)
+	}
+	return nil
+}
+
+func (a *artifactTypes) String() string {
+	return ""
+}
+
+// match returns true if the artifact type is one of the types
+func (a artifactTypes) match(artifactType string) bool {
+	for _, t := range a {
+		if t == artifactType {
+			return true
+		}
+	}
+	return false
+}
+
+// indexArtifactTypes maps the index media types to the image type, multi-arch images have no config
+var indexArtifactTypes = map[string]string{
+	manifestListMediaType: "application/vnd.docker.container.image.v1+json",
+	ociIndexMediaType:     "application/vnd.oci.image.config.v1+json",
+}
+
+// artifactType returns the artifact type of the image. Manifests without explicit
+// artifact type are identified by their config media type, indexes are images.
+func (image *Image) artifactType() string {
+	if image.ArtifactType != "" {
+		return image.ArtifactType
+	}
+	if image.ConfigType == "" && isIndex(image.MediaType) {
+		return indexArtifactTypes[image.MediaType]
+	}
+	return image.ConfigType
+}
+
+// filterArtifactTypes removes images which don't have one of the artifact types from the slice
+func filterArtifactTypes(images []*Image, types artifactTypes) ([]*Image, error) {
+	// Make sure the media types of all images are known
+	if err := setImageMediaType(images); err != nil {
+		return nil, err
+	}
+
+	i := 0
+	for _, image := range images {
+		if types.match(image.artifactType()) {
+			// This image should stay in slice
+			images[i] = image
+			i++
+		} else {
+			// Print information
+			logf(lineKept, "Image %s:%s has artifact type %s, skipped\n", image.Name, image.Tag, image.artifactType())
+			image.Reason = "artifact type " + image.artifactType()
+		}
+	}
+	return images[:i], nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// AuditEntry is a line of the audit log
+type AuditEntry struct {
+	Time       time.Time `json:"time"`
+	Actor      string    `json:"actor"`
+	Event      string    `json:"event"`
+	Repository string    `json:"repository"`
+	Tag        string    `json:"tag"`
+	Digest     string    `json:"digest,omitempty"`
+	Decision   string    `json:"decision,omitempty"`
+	Reason     string    `json:"reason,omitempty"`
+	Status     int       `json:"status,omitempty"`
+	DryRun     bool      `json:"dry_run"`
+}
+
+// auditLog is the audit file opened on first use
+var auditLog struct {
+	file *os.File
+	sync.Mutex
+}
+
+// audit appends the entry to the -audit-log file
+func audit(entry AuditEntry) error {
+	if Cfg.AuditLog == "" {
+		return nil
+	}
+	auditLog.Lock()
+	defer auditLog.Unlock()
+
+	// Open file in append mode, existing entries are never changed
+	if auditLog.file == nil {
+		f, err := os.OpenFile(Cfg.AuditLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		auditLog.file = f
+	}
+
+	entry.Time = time.Now().UTC()
+	entry.Actor = auditActor()
+	entry.DryRun = !Cfg.DeleteImages
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := auditLog.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return auditLog.file.Sync()
+}
+
+// auditActor returns the GitLab user running the CI job or the registry user
+func auditActor() string {
+	if user := os.Getenv("GITLAB_USER_LOGIN"); user != "" {
+		return user
+	}
+	return Cfg.Username
+}
+
+// auditDecisions writes the decision for every scanned tag
+func auditDecisions() error {
+	for _, repo := range scannedRepositories {
+		if err := auditRepository(repo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// auditRepository writes the decision for every tag of the repository
+func auditRepository(repo *Repository) error {
+	for _, image := range repo.Images {
+		err := audit(AuditEntry{
+			Event:      "decision",
+			Repository: image.Name,
+			Tag:        image.Tag,
+			Digest:     image.Digest,
+			Decision:   image.decision(),
+			Reason:     image.Reason,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
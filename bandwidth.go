@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// bandwidth is shared by all throttled transfers, so together they stay below -bandwidth-limit
+var bandwidth struct {
+	// next is the time the bytes read so far are allowed by the limit
+	next time.Time
+	sync.Mutex
+}
+
+// throttledReader reads at most -bandwidth-limit bytes per second
+type throttledReader struct {
+	io.Reader
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	// Read at most a second's worth at once
+	if limit := int64(Cfg.BandwidthLimit); int64(len(p)) > limit {
+		p = p[:limit]
+	}
+	n, err := r.Reader.Read(p)
+	waitBandwidth(n)
+	return n, err
+}
+
+// throttledKey marks the context of requests whose body is transferred at the pace of -bandwidth-limit
+type throttledKey struct{}
+
+// throttled marks the request as throttled transfer. It takes as long as the limit needs for the
+// size of the blob, so the request timeout doesn't apply.
+func throttled(req *http.Request) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), throttledKey{}, true))
+}
+
+// waitBandwidth sleeps until the limit allows the bytes
+func waitBandwidth(n int) {
+	bandwidth.Lock()
+	now := time.Now()
+	if bandwidth.next.Before(now) {
+		bandwidth.next = now
+	}
+	bandwidth.next = bandwidth.next.Add(time.Duration(float64(n) / float64(Cfg.BandwidthLimit) * float64(time.Second)))
+	wait := bandwidth.next.Sub(now)
+	bandwidth.Unlock()
+	time.Sleep(wait)
+}
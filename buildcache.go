@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// isBuildCache returns true if the image belongs to a Kaniko/BuildKit build cache
+func isBuildCache(image *Image) bool {
+	// Every tag of a cache repository is a cache tag
+	if strings.HasSuffix(image.Name, "/cache") || strings.HasSuffix(image.Name, "/buildcache") {
+		return true
+	}
+	return Cfg.CacheTagPattern.MatchString(image.Tag)
+}
+
+// splitBuildCache separates build cache images from application images
+func splitBuildCache(images []*Image) ([]*Image, []*Image) {
+	var cache []*Image
+	i := 0
+	for _, image := range images {
+		if isBuildCache(image) {
+			cache = append(cache, image)
+		} else {
+			// This image should stay in slice
+			images[i] = image
+			i++
+		}
+	}
+	return images[:i], cache
+}
+
+// expireBuildCache returns the cache images older than the cache expiry
+func expireBuildCache(cache []*Image) []*Image {
+	// Calculate cache expiry date
+	expiryDate := time.Now().AddDate(0, 0, Cfg.CacheMinExpiry*-1)
+
+	var expired []*Image
+	for _, image := range cache {
+		if image.Created.Before(expiryDate) {
+			expired = append(expired, image)
+		} else {
+			// Print information
+			logf(lineKept, "Build cache %s:%s is too young, skipped: %s\n", image.Name, image.Tag, image.Created.String())
+			image.Reason = "build cache too young"
+		}
+	}
+	return expired
+}
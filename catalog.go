@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+)
+
+const (
+	catalogURL = "%s/v2/_catalog?n=1000"
+)
+
+// linkNextRegexp extracts the next page of a Link header
+var linkNextRegexp = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+// getCatalog returns all repositories of the registry. Requires admin credentials.
+func getCatalog() ([]string, error) {
+	token, err := getRegistryTokenForScopes("registry:catalog:*")
+	if err != nil {
+		return nil, err
+	}
+
+	var repositories []string
+	pageURL := fmt.Sprintf(catalogURL, Cfg.RegistryURL)
+	for pageURL != "" {
+		body, resp, err := sendHTTPRequest(pageURL, token, "GET", false)
+		if err != nil {
+			return nil, err
+		}
+
+		// Extract repositories from response
+		var data struct {
+			Repositories []string `json:"repositories"`
+		}
+		if err := json.Unmarshal(body, &data); err != nil {
+			return nil, fmt.Errorf("invalid catalog response of %s: %w", pageURL, err)
+		}
+		repositories = append(repositories, data.Repositories...)
+		if pageURL, err = nextPageURL(resp); err != nil {
+			return nil, err
+		}
+	}
+	return repositories, nil
+}
+
+// nextPageURL returns the next page of the Link header or an empty string on the last page.
+// The link is relative to the registry.
+func nextPageURL(resp *http.Response) (string, error) {
+	match := linkNextRegexp.FindStringSubmatch(resp.Header.Get("Link"))
+	if match == nil {
+		return "", nil
+	}
+	base, err := url.Parse(Cfg.RegistryURL)
+	if err != nil {
+		return "", err
+	}
+	next, err := base.Parse(match[1])
+	if err != nil {
+		return "", err
+	}
+	return next.String(), nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// CheckpointEntry is a line of the checkpoint file, either a decided repository or a finished deletion
+type CheckpointEntry struct {
+	Event      string            `json:"event"`
+	Repository string            `json:"repository"`
+	TagCount   int               `json:"tag_count,omitempty"`
+	Images     []CheckpointImage `json:"images,omitempty"`
+	Tag        string            `json:"tag,omitempty"`
+	Result     string            `json:"result,omitempty"`
+}
+
+// CheckpointImage is a tag of a decided repository
+type CheckpointImage struct {
+	InventoryImage
+	Reason    string `json:"reason,omitempty"`
+	Candidate bool   `json:"candidate,omitempty"`
+}
+
+// checkpoint is the checkpoint file opened on first use with the progress of the interrupted run
+var checkpoint struct {
+	file    *os.File
+	decided map[string]CheckpointEntry
+	results map[string]string
+	sync.Mutex
+}
+
+// openCheckpoint reads the progress of the interrupted run if -resume is given and opens
+// the file for appending. Without -resume a new checkpoint is started. The lock must be held.
+func openCheckpoint() error {
+	if checkpoint.file != nil {
+		return nil
+	}
+	checkpoint.decided = make(map[string]CheckpointEntry)
+	checkpoint.results = make(map[string]string)
+	flags := os.O_APPEND | os.O_CREATE | os.O_WRONLY
+	if Cfg.Resume {
+		f, err := os.Open(Cfg.Checkpoint)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err == nil {
+			scanner := bufio.NewScanner(f)
+			scanner.Buffer(nil, 1<<30)
+			for scanner.Scan() {
+				var entry CheckpointEntry
+				if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+					// The last line is incomplete if the run was killed while writing it
+					break
+				}
+				switch entry.Event {
+				case "decided":
+					checkpoint.decided[entry.Repository] = entry
+				case "deleted":
+					checkpoint.results[entry.Repository+":"+entry.Tag] = entry.Result
+				}
+			}
+			f.Close()
+			if err := scanner.Err(); err != nil {
+				return err
+			}
+			fmt.Printf("Resuming run with %d decided repositories and %d finished deletions\n", len(checkpoint.decided), len(checkpoint.results))
+		}
+	} else {
+		flags |= os.O_TRUNC
+	}
+
+	f, err := os.OpenFile(Cfg.Checkpoint, flags, 0644)
+	if err != nil {
+		return err
+	}
+	checkpoint.file = f
+	return nil
+}
+
+// writeCheckpoint appends the entry to the checkpoint file. The lock must be held.
+func writeCheckpoint(entry CheckpointEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := checkpoint.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return checkpoint.file.Sync()
+}
+
+// checkpointCandidates returns the images to delete of the repository from the interrupted run.
+// The images are not fetched and decided again. Returns false if the repository wasn't decided yet.
+func checkpointCandidates(path string) ([]*Image, bool, error) {
+	if Cfg.Checkpoint == "" {
+		return nil, false, nil
+	}
+	checkpoint.Lock()
+	defer checkpoint.Unlock()
+	if err := openCheckpoint(); err != nil {
+		return nil, false, err
+	}
+	repo := newRepository(path)
+	entry, ok := checkpoint.decided[repo.Name]
+	if !ok {
+		return nil, false, nil
+	}
+
+	// Restore the images with their decisions
+	var candidates []*Image
+	repo.TagCount = entry.TagCount
+	for _, i := range entry.Images {
+		image := i.image(repo)
+		image.Reason = i.Reason
+		repo.Images = append(repo.Images, image)
+		if i.Candidate {
+			candidates = append(candidates, image)
+		}
+	}
+	scannedRepositories = append(scannedRepositories, repo)
+	if len(candidates) > 0 && offlineInventory == nil {
+		token, err := getRegistryToken(repo)
+		if err != nil {
+			return nil, false, err
+		}
+		repo.Token = token
+	}
+	logf(lineInfo, "Repository %s was decided before, %d images to delete\n", repo.Name, len(candidates))
+	return candidates, true, nil
+}
+
+// checkpointDecided records the decided images of the repository
+func checkpointDecided(repo *Repository, candidates []*Image) error {
+	if Cfg.Checkpoint == "" {
+		return nil
+	}
+	checkpoint.Lock()
+	defer checkpoint.Unlock()
+	if err := openCheckpoint(); err != nil {
+		return err
+	}
+	isCandidate := make(map[*Image]bool)
+	for _, image := range candidates {
+		isCandidate[image] = true
+	}
+	entry := CheckpointEntry{Event: "decided", Repository: repo.Name, TagCount: repo.TagCount}
+	for _, image := range repo.Images {
+		entry.Images = append(entry.Images, CheckpointImage{InventoryImage: inventoryImage(image), Reason: image.Reason, Candidate: isCandidate[image]})
+	}
+	return writeCheckpoint(entry)
+}
+
+// checkpointResult returns the result of the image if the interrupted run deleted it already
+func checkpointResult(image *Image) (string, bool, error) {
+	if Cfg.Checkpoint == "" {
+		return "", false, nil
+	}
+	checkpoint.Lock()
+	defer checkpoint.Unlock()
+	if err := openCheckpoint(); err != nil {
+		return "", false, err
+	}
+	result, ok := checkpoint.results[image.Name+":"+image.Tag]
+	return result, ok, nil
+}
+
+// checkpointDeleted records that the image is gone
+func checkpointDeleted(image *Image) error {
+	if Cfg.Checkpoint == "" {
+		return nil
+	}
+	checkpoint.Lock()
+	defer checkpoint.Unlock()
+	if err := openCheckpoint(); err != nil {
+		return err
+	}
+	return writeCheckpoint(CheckpointEntry{Event: "deleted", Repository: image.Name, Tag: image.Tag, Result: image.Result})
+}
+
+// removeCheckpoint deletes the checkpoint file once the run is complete
+func removeCheckpoint() error {
+	if Cfg.Checkpoint == "" {
+		return nil
+	}
+	checkpoint.Lock()
+	defer checkpoint.Unlock()
+	if checkpoint.file != nil {
+		checkpoint.file.Close()
+		checkpoint.file = nil
+	}
+	if err := os.Remove(Cfg.Checkpoint); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	metadataURL      = "%s/api/v4/metadata"
+	clusterAgentsURL = "%s/api/v4/projects/%s/cluster_agents?per_page=100"
+)
+
+// Cluster represents a kubernetes cluster where images are looked up
+type Cluster struct {
+	Name   string
+	Config *rest.Config
+}
+
+type agentProjectFlags []string
+
+// Clusters are resolved once and shared by all cluster lookups
+var clusters []Cluster
+
+// getClusters returns the clusters of all kubeconfig files, the credentials secret and GitLab agents
+func getClusters() ([]Cluster, error) {
+	if clusters != nil {
+		return clusters, nil
+	}
+	resolved := []Cluster{}
+
+	// Clusters from kubeconfig files
+	for _, c := range Cfg.KubeConfig {
+		config, err := clientcmd.BuildConfigFromFlags("", c)
+		if err != nil {
+			return nil, err
+		}
+		resolved = append(resolved, Cluster{Name: c, Config: config})
+	}
+
+	// Clusters from kubeconfigs of the credentials secret
+	for _, c := range secretKubeConfigs {
+		kubeConfig, err := clientcmd.Load(c.Data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid kubeconfig %s: %w", c.Name, err)
+		}
+		config, err := clientcmd.NewDefaultClientConfig(*kubeConfig, &clientcmd.ConfigOverrides{}).ClientConfig()
+		if err != nil {
+			return nil, fmt.Errorf("invalid kubeconfig %s: %w", c.Name, err)
+		}
+		resolved = append(resolved, Cluster{Name: c.Name, Config: config})
+	}
+
+	// Clusters connected via GitLab agents
+	if len(Cfg.AgentProjects) > 0 {
+		agentClusters, err := getAgentClusters()
+		if err != nil {
+			return nil, err
+		}
+		resolved = append(resolved, agentClusters...)
+	}
+	clusters = resolved
+	return clusters, nil
+}
+
+// getAgentClusters returns the clusters of the agents registered in the agent projects.
+// Requests are sent through the KAS kubernetes API proxy, which requires user access
+// to be enabled in the agent configuration.
+func getAgentClusters() ([]Cluster, error) {
+	// Get kubernetes API proxy url of KAS
+	body, resp, err := sendGitlabRequest(fmt.Sprintf(metadataURL, Cfg.GitlabURL), "GET", nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("cannot get GitLab metadata: %w", newResponseError(resp, body))
+	}
+	var metadata struct {
+		KAS struct {
+			Enabled             bool   `json:"enabled"`
+			ExternalK8sProxyURL string `json:"externalK8sProxyUrl"`
+		} `json:"kas"`
+	}
+	if err := json.Unmarshal(body, &metadata); err != nil {
+		return nil, fmt.Errorf("invalid GitLab metadata: %w", err)
+	}
+	if !metadata.KAS.Enabled || metadata.KAS.ExternalK8sProxyURL == "" {
+		fmt.Println("GitLab agent server (KAS) is not available, agent clusters skipped")
+		return nil, nil
+	}
+
+	var agentClusters []Cluster
+	for _, project := range Cfg.AgentProjects {
+		// Get agents of the project
+		body, resp, err := sendGitlabRequest(fmt.Sprintf(clusterAgentsURL, Cfg.GitlabURL, url.PathEscape(project)), "GET", nil)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			fmt.Printf("Cannot get agents of %s, return code: %d\n", project, resp.StatusCode)
+			continue
+		}
+		var agents []struct {
+			ID   int    `json:"id"`
+			Name string `json:"name"`
+		}
+		if err := json.Unmarshal(body, &agents); err != nil {
+			return nil, fmt.Errorf("invalid agents of %s: %w", project, err)
+		}
+
+		// Authenticate at the proxy with agent id and personal access token
+		for _, agent := range agents {
+			agentClusters = append(agentClusters, Cluster{
+				Name: fmt.Sprintf("%s/%s", project, agent.Name),
+				Config: &rest.Config{
+					Host:            metadata.KAS.ExternalK8sProxyURL,
+					BearerToken:     fmt.Sprintf("pat:%d:%s", agent.ID, apiToken()),
+					TLSClientConfig: agentTLSConfig(),
+					WrapTransport:   agentProxy,
+				},
+			})
+		}
+	}
+	return agentClusters, nil
+}
+
+// agentTLSConfig returns the TLS settings of the agent server proxy, which runs on the GitLab domain
+func agentTLSConfig() rest.TLSClientConfig {
+	config := rest.TLSClientConfig{CertFile: Cfg.ClientCert, KeyFile: Cfg.ClientKey}
+
+	// Client-go refuses a CA file for insecure connections
+	if Cfg.InsecureSkipTLSVerify {
+		config.Insecure = true
+	} else {
+		config.CAFile = Cfg.CACert
+	}
+	return config
+}
+
+// agentProxy sends the requests to the agent server proxy through the proxy of the GitLab requests.
+// Client-go passes its TLS transport, which takes the proxy from the environment.
+func agentProxy(rt http.RoundTripper) http.RoundTripper {
+	if t, ok := rt.(*http.Transport); ok {
+		t.Proxy = proxyURL
+	}
+	return rt
+}
+
+func (a *agentProjectFlags) Set(value string) error {
+	*a = append(*a, value)
+	return nil
+}
+
+func (a *agentProjectFlags) String() string {
+	return ""
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+)
+
+// ANSI escape sequences
+const (
+	colorReset   = "\033[0m"
+	colorRed     = "\033[31m"
+	colorGreen   = "\033[32m"
+	colorYellow  = "\033[33m"
+	colorBoldRed = "\033[1;31m"
+)
+
+// colorEnabled is true if output lines are colored
+var colorEnabled bool
+
+// initColor enables colors if stdout is a terminal and neither -no-color nor NO_COLOR is set
+func initColor() {
+	colorEnabled = !Cfg.NoColor && os.Getenv("NO_COLOR") == "" && isTerminal(os.Stdout)
+}
+
+// isTerminal returns true if the file is a terminal
+func isTerminal(f *os.File) bool {
+	stat, err := f.Stat()
+	return err == nil && stat.Mode()&os.ModeCharDevice != 0
+}
+
+// colorize wraps the text in the color if colors are enabled
+func colorize(color, text string) string {
+	if !colorEnabled || color == "" {
+		return text
+	}
+	return color + text + colorReset
+}
+
+// lineKind is the decision a progress line reports
+type lineKind int
+
+// Kinds of progress lines
+const (
+	lineInfo lineKind = iota
+	lineKept
+	lineProtected
+	lineDeleted
+)
+
+// lineColor returns the color of a progress line: protected is yellow, kept is green and deleted is red
+func lineColor(kind lineKind) string {
+	switch kind {
+	case lineProtected:
+		return colorYellow
+	case lineKept:
+		return colorGreen
+	case lineDeleted:
+		return colorRed
+	}
+	return ""
+}
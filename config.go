@@ -0,0 +1,144 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"gopkg.in/yaml.v2"
+)
+
+// loadConfigFile sets all flags which were not given on the command line from the YAML file.
+// Keys are the flag names, lists set repeatable flags once per item.
+func loadConfigFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var values map[string]interface{}
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("cannot parse config file %s: %s", path, err.Error())
+	}
+
+	// Flags given on the command line take precedence
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	for key, value := range values {
+		// Registries are pruned one after the other
+		if key == "registries" {
+			if err := loadRegistries(value); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Repositories can carry their own retention policy
+		if key == "repositories" {
+			if err := loadRepositoryPolicies(value, !set["repository"]); err != nil {
+				return err
+			}
+			continue
+		}
+		if set[key] {
+			continue
+		}
+
+		// Lists set repeatable flags once per item
+		items, ok := value.([]interface{})
+		if !ok {
+			items = []interface{}{value}
+		}
+		for _, item := range items {
+			if err := flag.Set(key, fmt.Sprint(item)); err != nil {
+				return fmt.Errorf("invalid config value for %s: %s", key, err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+// RepositoryPolicy overrides the retention settings for repositories matching the path
+type RepositoryPolicy struct {
+	Path                string      `yaml:"path"`
+	MinExpiry           *int        `yaml:"minexpiry"`
+	RegexPatterns       regexpFlags `yaml:"regexp"`
+	KeepRegexPatterns   regexpFlags `yaml:"keep-regexp"`
+	DeleteRegexPatterns regexpFlags `yaml:"delete-regexp"`
+	KeepLatest          *int        `yaml:"keep-latest"`
+	Signatures          string      `yaml:"signatures"`
+}
+
+// loadRepositoryPolicies reads the repositories list of the config file. Items are either a
+// repository path or a policy with path and retention settings.
+func loadRepositoryPolicies(value interface{}, addRepositories bool) error {
+	items, ok := value.([]interface{})
+	if !ok {
+		return fmt.Errorf("invalid config value for repositories: expected a list")
+	}
+
+	for _, item := range items {
+		policy := &RepositoryPolicy{}
+		if path, ok := item.(string); ok {
+			policy.Path = path
+		} else {
+			// Decode item into policy
+			data, err := yaml.Marshal(item)
+			if err != nil {
+				return err
+			}
+			if err := yaml.UnmarshalStrict(data, policy); err != nil {
+				return fmt.Errorf("invalid config value for repositories: %s", err.Error())
+			}
+			if policy.Path == "" {
+				return fmt.Errorf("invalid config value for repositories: path is missing")
+			}
+			if policy.Signatures != "" && !signatureModes[policy.Signatures] {
+				return fmt.Errorf("invalid config value for repositories: unknown signatures mode %q", policy.Signatures)
+			}
+			Cfg.RepositoryPolicies = append(Cfg.RepositoryPolicies, policy)
+		}
+
+		// Repositories given on the command line take precedence
+		if addRepositories {
+			Cfg.Repositories = append(Cfg.Repositories, policy.Path)
+		}
+	}
+	return nil
+}
+
+// loadRegistries reads the registries list of the config file. Credentials can reference
+// environment variables like ${REGISTRY_PASSWORD}.
+func loadRegistries(value interface{}) error {
+	data, err := yaml.Marshal(value)
+	if err != nil {
+		return err
+	}
+	if err := yaml.UnmarshalStrict(data, &Cfg.Registries); err != nil {
+		return fmt.Errorf("invalid config value for registries: %s", err.Error())
+	}
+
+	names := make(map[string]bool)
+	for _, registry := range Cfg.Registries {
+		if registry.RegistryURL == "" {
+			return fmt.Errorf("invalid config value for registries: registryurl is missing")
+		}
+		if registry.Name == "" {
+			registry.Name = dockerHost(registry.RegistryURL)
+		}
+		if names[registry.Name] {
+			return fmt.Errorf("invalid config value for registries: duplicate name %s, add distinct names", registry.Name)
+		}
+		names[registry.Name] = true
+		for _, field := range []*string{&registry.Username, &registry.Password, &registry.Token, &registry.DeployTokenUser, &registry.DeployToken} {
+			*field = os.ExpandEnv(*field)
+		}
+		if (registry.DeployTokenUser == "") != (registry.DeployToken == "") {
+			return fmt.Errorf("invalid config value for registries: deploy-token-user and deploy-token of %s must be given together", registry.Name)
+		}
+	}
+	return nil
+}
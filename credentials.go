@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// ciJobTokenUsername is the username of CI job tokens, CI_REGISTRY_USER in jobs
+const ciJobTokenUsername = "gitlab-ci-token"
+
+// tokenUsername is sent with personal access tokens if no -user is given, GitLab only checks the token
+const tokenUsername = "gitlab-registry-pruner"
+
+// errNoAPIToken is returned for GitLab API requests if only a deploy token is given
+var errNoAPIToken = errors.New("deploy tokens can't access the GitLab API, add -token with the api scope")
+
+// registryCredentials returns the username and password for the registry token request
+func registryCredentials() (string, string) {
+	// Deploy tokens are made for registry access by automation
+	if Cfg.DeployToken != "" {
+		return Cfg.DeployTokenUser, Cfg.DeployToken
+	}
+	if Cfg.JobToken != "" {
+		return ciJobTokenUsername, Cfg.JobToken
+	}
+	if Cfg.Token != "" {
+		if Cfg.Username == "" {
+			return tokenUsername, Cfg.Token
+		}
+		return Cfg.Username, Cfg.Token
+	}
+	return Cfg.Username, Cfg.Password
+}
+
+// apiToken returns the token of GitLab API requests. Passwords only work for accounts without 2FA.
+func apiToken() string {
+	if Cfg.Token != "" {
+		return Cfg.Token
+	}
+	return Cfg.Password
+}
+
+// skipAPIChecks turns off the checks which need the GitLab API if no API token is given.
+// Deploy tokens and job tokens can't read protected tag rules or pipelines.
+func skipAPIChecks() {
+	if apiToken() != "" {
+		return
+	}
+	if Cfg.ProtectedTags {
+		fmt.Fprintln(os.Stderr, "WARNING: no -token given, protected tag rules are not checked")
+		Cfg.ProtectedTags = false
+	}
+	if Cfg.RunningPipelines {
+		fmt.Fprintln(os.Stderr, "WARNING: no -token given, running pipelines are not checked")
+		Cfg.RunningPipelines = false
+	}
+}
+
+// setGitlabAuth authenticates the GitLab API request
+func setGitlabAuth(req *http.Request) {
+	// Job tokens only work for some endpoints like packages
+	if Cfg.JobToken != "" {
+		req.Header.Set("JOB-TOKEN", Cfg.JobToken)
+		return
+	}
+	req.Header.Set("PRIVATE-TOKEN", apiToken())
+}
+
+// useCIJobToken takes the GitLab instance, the registry, the project and its job token from the
+// variables of the GitLab CI job if no credentials are given. The job token can delete the images
+// of its own project.
+func useCIJobToken() {
+	token := os.Getenv("CI_JOB_TOKEN")
+	if token == "" || Cfg.Username != "" || Cfg.Password != "" || Cfg.Token != "" || Cfg.DeployToken != "" {
+		return
+	}
+	Cfg.JobToken = token
+	if Cfg.GitlabURL == "" {
+		Cfg.GitlabURL = os.Getenv("CI_SERVER_URL")
+	}
+	if Cfg.RegistryURL == "" {
+		Cfg.RegistryURL = os.Getenv("CI_REGISTRY")
+	}
+	if len(Cfg.Repositories) == 0 && !Cfg.Catalog && os.Getenv("CI_PROJECT_PATH") != "" {
+		Cfg.Repositories = append(Cfg.Repositories, os.Getenv("CI_PROJECT_PATH"))
+	}
+	fmt.Fprintf(os.Stderr, "Using the CI job token for %s\n", strings.Join(Cfg.Repositories, ", "))
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// secretKubeConfig is a kubeconfig of the credentials secret
+type secretKubeConfig struct {
+	Name string
+	Data []byte
+}
+
+// secretKubeConfigs are the kubeconfigs of the credentials secret. They are kept in
+// memory, files would be left behind when the run exits early.
+var secretKubeConfigs []secretKubeConfig
+
+// useCredentialsSecret takes the credentials missing on the command line from the keys username,
+// password, token, deploy-token-user and deploy-token of the -credentials-secret. Keys named
+// kubeconfig or ending with .kubeconfig add the clusters to scan.
+func useCredentialsSecret() error {
+	parts := strings.SplitN(Cfg.CredentialsSecret, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("invalid -credentials-secret %q, expected namespace/name", Cfg.CredentialsSecret)
+	}
+
+	// Read the secret with the service account of the pod
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return fmt.Errorf("-credentials-secret only works inside a cluster: %w", err)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+	secret, err := clientset.CoreV1Client.Secrets(parts[0]).Get(parts[1])
+	if err != nil {
+		return fmt.Errorf("cannot read credentials secret %s: %w", Cfg.CredentialsSecret, err)
+	}
+
+	// Credentials given as flags take precedence
+	set := func(value *string, key string) {
+		if data, ok := secret.Data[key]; ok && *value == "" {
+			*value = strings.TrimSpace(string(data))
+		}
+	}
+	set(&Cfg.Username, "username")
+	set(&Cfg.Password, "password")
+	set(&Cfg.Token, "token")
+	set(&Cfg.DeployTokenUser, "deploy-token-user")
+	set(&Cfg.DeployToken, "deploy-token")
+
+	// Keep kubeconfigs for the cluster lookup
+	var keys []string
+	for key := range secret.Data {
+		if key == "kubeconfig" || strings.HasSuffix(key, ".kubeconfig") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		name := fmt.Sprintf("%s/%s", Cfg.CredentialsSecret, key)
+		secretKubeConfigs = append(secretKubeConfigs, secretKubeConfig{name, secret.Data[key]})
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	dependencyProxyManifestsQuery = `query($fullPath: ID!, $after: String) {
+  group(fullPath: $fullPath) {
+    dependencyProxyManifests(first: 100, after: $after) {
+      nodes { imageName digest size createdAt updatedAt }
+      pageInfo { hasNextPage endCursor }
+    }
+  }
+}`
+	dependencyProxyTTLMutation = `mutation($groupPath: ID!, $ttl: Int!) {
+  updateDependencyProxyImageTtlGroupPolicy(input: {groupPath: $groupPath, enabled: true, ttl: $ttl}) {
+    errors
+  }
+}`
+)
+
+// DependencyProxyManifest represents a manifest cached by the group dependency proxy
+type DependencyProxyManifest struct {
+	ImageName string    `json:"imageName"`
+	Digest    string    `json:"digest"`
+	Size      string    `json:"size"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// pruneDependencyProxy reports expired dependency proxy manifests of the group and, if deletion is
+// requested, sets the group's cleanup policy so GitLab expires them. The API offers no way to delete
+// single proxy entries.
+func pruneDependencyProxy() error {
+	// --- Get all cached manifests ---
+	manifests, err := getDependencyProxyManifests(Cfg.DependencyProxyGroup)
+	if err != nil {
+		return err
+	}
+
+	// --- Print manifests which weren't pulled within the expiry ---
+	minExpiryDate := time.Now().AddDate(0, 0, Cfg.MinExpiry*-1)
+	expired := 0
+	for _, m := range manifests {
+		if m.UpdatedAt.Before(minExpiryDate) {
+			logf(lineDeleted, "Dependency proxy manifest expired: %s (%s), last used: %s\n", m.ImageName, m.Digest, m.UpdatedAt.String())
+			expired++
+		}
+	}
+	fmt.Printf("%d of %d dependency proxy manifests are older than %d days\n", expired, len(manifests), Cfg.MinExpiry)
+	printDryRunNotice()
+
+	// --- Give the user the chance to think about it ---
+	if Cfg.DeleteImages {
+		if !Cfg.Yes {
+			if ok, err := confirmDeletion(); !ok {
+				return err
+			}
+		}
+
+		// Let GitLab expire manifests which weren't pulled within the expiry
+		var result struct {
+			UpdateDependencyProxyImageTtlGroupPolicy struct {
+				Errors []string `json:"errors"`
+			} `json:"updateDependencyProxyImageTtlGroupPolicy"`
+		}
+		variables := map[string]interface{}{"groupPath": Cfg.DependencyProxyGroup, "ttl": Cfg.MinExpiry}
+		if err := sendGitlabGraphQL(dependencyProxyTTLMutation, variables, &result); err != nil {
+			return err
+		}
+		if errs := result.UpdateDependencyProxyImageTtlGroupPolicy.Errors; len(errs) > 0 {
+			return errors.New(errs[0])
+		}
+		fmt.Printf("Dependency proxy cleanup policy of %s set to %d days\n", Cfg.DependencyProxyGroup, Cfg.MinExpiry)
+	}
+	return nil
+}
+
+func getDependencyProxyManifests(group string) ([]DependencyProxyManifest, error) {
+	var manifests []DependencyProxyManifest
+	variables := map[string]interface{}{"fullPath": group}
+	for {
+		var result struct {
+			Group *struct {
+				DependencyProxyManifests struct {
+					Nodes    []DependencyProxyManifest `json:"nodes"`
+					PageInfo struct {
+						HasNextPage bool   `json:"hasNextPage"`
+						EndCursor   string `json:"endCursor"`
+					} `json:"pageInfo"`
+				} `json:"dependencyProxyManifests"`
+			} `json:"group"`
+		}
+		if err := sendGitlabGraphQL(dependencyProxyManifestsQuery, variables, &result); err != nil {
+			return nil, err
+		}
+		if result.Group == nil {
+			return nil, fmt.Errorf("group %s not found", group)
+		}
+
+		// Collect manifests and go to the next page
+		page := result.Group.DependencyProxyManifests
+		manifests = append(manifests, page.Nodes...)
+		if !page.PageInfo.HasNextPage {
+			return manifests, nil
+		}
+		variables["after"] = page.PageInfo.EndCursor
+	}
+}
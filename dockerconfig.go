@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// DockerConfig is the part of the Docker config file with registry credentials
+type DockerConfig struct {
+	Auths map[string]struct {
+		Auth     string `json:"auth"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+	} `json:"auths"`
+	CredsStore  string            `json:"credsStore"`
+	CredHelpers map[string]string `json:"credHelpers"`
+}
+
+// useDockerConfig takes the credentials of the registry from the Docker config file written by docker login,
+// from $DOCKER_CONFIG/config.json or ~/.docker/config.json. Credentials given as flags take precedence.
+func useDockerConfig() error {
+	if Cfg.Password != "" || Cfg.Token != "" {
+		return nil
+	}
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return fmt.Errorf("cannot find the Docker config: %w", err)
+		}
+		dir = filepath.Join(home, ".docker")
+	}
+	file := filepath.Join(dir, "config.json")
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("cannot read the Docker config: %w", err)
+	}
+	var config DockerConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("invalid Docker config %s: %w", file, err)
+	}
+
+	host := dockerHost(endpointURL(Cfg.RegistryURL))
+	username, password, err := config.credentials(host)
+	if err != nil {
+		return err
+	}
+	if username == "" {
+		return fmt.Errorf("Docker config %s has no credentials for %s, run docker login %s", file, host, host)
+	}
+	Cfg.Username, Cfg.Password = username, password
+	return nil
+}
+
+// credentials returns the username and password of the registry host, asking the credential helper if one is configured
+func (c *DockerConfig) credentials(host string) (string, string, error) {
+	helper := c.CredHelpers[host]
+	if helper == "" {
+		helper = c.CredsStore
+	}
+	if helper != "" {
+		return credentialHelper(helper, host)
+	}
+
+	for key, auth := range c.Auths {
+		if dockerHost(key) != host {
+			continue
+		}
+		if auth.Auth == "" {
+			return auth.Username, auth.Password, nil
+		}
+		decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid auth of %s in the Docker config: %w", key, err)
+		}
+		parts := strings.SplitN(string(decoded), ":", 2)
+		if len(parts) != 2 {
+			return "", "", fmt.Errorf("invalid auth of %s in the Docker config, expected username:password", key)
+		}
+		return parts[0], parts[1], nil
+	}
+	return "", "", nil
+}
+
+// credentialHelper gets the credentials of the host from the docker-credential-<helper> program
+func credentialHelper(helper, host string) (string, string, error) {
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(host)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		// Helpers print "credentials not found in native keychain" for unknown hosts
+		if strings.Contains(string(out)+stderr.String(), "credentials not found") {
+			return "", "", nil
+		}
+		return "", "", fmt.Errorf("docker-credential-%s failed: %w: %s", helper, err, strings.TrimSpace(string(out)+stderr.String()))
+	}
+	var creds struct {
+		Username string `json:"Username"`
+		Secret   string `json:"Secret"`
+	}
+	if err := json.Unmarshal(out, &creds); err != nil {
+		return "", "", fmt.Errorf("invalid output of docker-credential-%s: %w", helper, err)
+	}
+	return creds.Username, creds.Secret, nil
+}
+
+// dockerHost returns the host of a registry address, which Docker keeps with or without scheme and path
+func dockerHost(address string) string {
+	if !strings.Contains(address, "://") {
+		address = "https://" + address
+	}
+	u, err := url.Parse(address)
+	if err != nil {
+		return address
+	}
+	return u.Host
+}
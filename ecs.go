@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ecs"
+)
+
+type ecsClusterFlags []string
+
+// getECSReferences returns the images used by services or running tasks of the ECS cluster
+func getECSReferences(cluster string) ([]imageReference, error) {
+	defer startDetachedSpan("ecs scan", "cluster", cluster).finish()
+	config := &aws.Config{}
+	if Cfg.ECSRegion != "" {
+		config.Region = aws.String(Cfg.ECSRegion)
+	}
+	sess, err := session.NewSession(config)
+	if err != nil {
+		return nil, err
+	}
+	svc := ecs.New(sess)
+
+	// Collect task definitions of services and running tasks, errors of the pages stop the paging
+	taskDefinitions := make(map[string]bool)
+	var pageErr error
+	err = svc.ListServicesPages(&ecs.ListServicesInput{Cluster: aws.String(cluster)},
+		func(page *ecs.ListServicesOutput, lastPage bool) bool {
+			if len(page.ServiceArns) == 0 {
+				return true
+			}
+			services, err := svc.DescribeServices(&ecs.DescribeServicesInput{
+				Cluster:  aws.String(cluster),
+				Services: page.ServiceArns,
+			})
+			if err != nil {
+				pageErr = err
+				return false
+			}
+			for _, service := range services.Services {
+				// Deployments include the task definitions of rolling updates
+				for _, deployment := range service.Deployments {
+					taskDefinitions[aws.StringValue(deployment.TaskDefinition)] = true
+				}
+			}
+			return true
+		})
+	if err = firstError(err, pageErr); err != nil {
+		return nil, err
+	}
+	err = svc.ListTasksPages(&ecs.ListTasksInput{Cluster: aws.String(cluster)},
+		func(page *ecs.ListTasksOutput, lastPage bool) bool {
+			if len(page.TaskArns) == 0 {
+				return true
+			}
+			tasks, err := svc.DescribeTasks(&ecs.DescribeTasksInput{
+				Cluster: aws.String(cluster),
+				Tasks:   page.TaskArns,
+			})
+			if err != nil {
+				pageErr = err
+				return false
+			}
+			for _, task := range tasks.Tasks {
+				taskDefinitions[aws.StringValue(task.TaskDefinitionArn)] = true
+			}
+			return true
+		})
+	if err = firstError(err, pageErr); err != nil {
+		return nil, err
+	}
+
+	// Get container images of all task definitions
+	var refs []imageReference
+	for taskDefinition := range taskDefinitions {
+		def, err := svc.DescribeTaskDefinition(&ecs.DescribeTaskDefinitionInput{
+			TaskDefinition: aws.String(taskDefinition),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, cont := range def.TaskDefinition.ContainerDefinitions {
+			refs = append(refs, imageReference{
+				Cluster:  "ecs/" + cluster,
+				Ref:      aws.StringValue(cont.Image),
+				Location: fmt.Sprintf("ECS cluster %s and task definition %s", cluster, taskDefinition),
+			})
+		}
+	}
+	return refs, nil
+}
+
+func (e *ecsClusterFlags) Set(value string) error {
+	*e = append(*e, value)
+	return nil
+}
+
+func (e *ecsClusterFlags) String() string {
+	return ""
+}
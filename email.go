@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// sendEmail sends the summary with the results as CSV and the summary as JSON attachment
+func sendEmail(s Summary) error {
+	recipients := strings.Split(Cfg.SMTPTo, ",")
+	for i := range recipients {
+		recipients[i] = strings.TrimSpace(recipients[i])
+	}
+
+	// Create attachments
+	var results bytes.Buffer
+	if err := writeCSV(&results); err != nil {
+		return err
+	}
+	summary, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Create multipart message
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part, err := w.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=utf-8"}})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(part, s.text())
+	attachments := []struct {
+		name string
+		data []byte
+	}{{"results.csv", results.Bytes()}, {"summary.json", summary}}
+	for _, attachment := range attachments {
+		part, err := w.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {"application/octet-stream"},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {fmt.Sprintf("attachment; filename=%q", attachment.name)},
+		})
+		if err != nil {
+			return err
+		}
+		encoded := base64.StdEncoding.EncodeToString(attachment.data)
+		for len(encoded) > 76 {
+			fmt.Fprintln(part, encoded[:76])
+			encoded = encoded[76:]
+		}
+		fmt.Fprintln(part, encoded)
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	subject := "Registry prune report"
+	if s.DryRun {
+		subject += " (dry run)"
+	}
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nMIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=%s\r\n\r\n%s",
+		Cfg.SMTPFrom, strings.Join(recipients, ", "), subject, time.Now().Format(time.RFC1123Z), w.Boundary(), body.String())
+
+	// Authenticate only if credentials are given
+	var auth smtp.Auth
+	if Cfg.SMTPUser != "" {
+		host, _, err := net.SplitHostPort(Cfg.SMTPServer)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", Cfg.SMTPUser, Cfg.SMTPPassword, host)
+	}
+	return smtp.SendMail(Cfg.SMTPServer, auth, Cfg.SMTPFrom, recipients, []byte(msg))
+}
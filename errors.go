@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// errorBodyLimit is the number of response body bytes in error messages
+const errorBodyLimit = 200
+
+// responseError is a response of the registry or GitLab with an unexpected status
+type responseError struct {
+	Method string
+	URL    string
+	Status int
+	Body   string
+}
+
+// newResponseError returns the error of the response with the beginning of its body
+func newResponseError(resp *http.Response, body []byte) error {
+	e := &responseError{Status: resp.StatusCode, Body: strings.TrimSpace(string(body))}
+	if resp.Request != nil {
+		e.Method, e.URL = resp.Request.Method, resp.Request.URL.Redacted()
+	}
+	if len(e.Body) > errorBodyLimit {
+		e.Body = e.Body[:errorBodyLimit] + "..."
+	}
+	return e
+}
+
+func (e *responseError) Error() string {
+	msg := fmt.Sprintf("%s %s returned %d %s", e.Method, e.URL, e.Status, http.StatusText(e.Status))
+	if e.Body != "" {
+		msg += ": " + e.Body
+	}
+	if hint := statusHint(e.Status); hint != "" {
+		msg += ". " + hint
+	}
+	return msg
+}
+
+// statusHint explains what to do about the status
+func statusHint(status int) string {
+	switch status {
+	case http.StatusUnauthorized:
+		return "Check -user and -password or -token, tokens need the read_registry and write_registry scopes or the api scope for GitLab API requests"
+	case http.StatusForbidden:
+		return "The user lacks the permission, deleting needs at least the Maintainer role"
+	case http.StatusTooManyRequests:
+		return "The server is rate limiting, increase -rate-limit-retries or reduce -workers"
+	}
+	if status >= 500 {
+		return "The server failed, increase -retries if this happens only now and then"
+	}
+	return ""
+}
+
+// contextError is an error of a concurrent worker with the context it was working on
+type contextError struct {
+	ErrorContext
+	err error
+}
+
+func (e *contextError) Error() string {
+	return e.err.Error()
+}
+
+func (e *contextError) Unwrap() error {
+	return e.err
+}
+
+// withContext adds the repository and tag the caller was working on to the error,
+// unless a worker added its context already
+func withContext(err error, repository, tag string) error {
+	if err == nil || workerContext(err) != nil {
+		return err
+	}
+	return &contextError{newErrorContext(repository, tag), err}
+}
+
+// workerContext returns the context withContext added to the error or nil
+func workerContext(err error) *ErrorContext {
+	var e *contextError
+	if errors.As(err, &e) {
+		return &e.ErrorContext
+	}
+	return nil
+}
+
+// errUsage is returned by commands called with invalid arguments once they printed their usage
+var errUsage = errors.New("invalid arguments")
+
+// firstError returns the first error which is not nil, deferred steps use it to keep the error of the run
+func firstError(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// panicError returns the recovered value as error
+func panicError(r interface{}) error {
+	if err, ok := r.(error); ok {
+		return err
+	}
+	return fmt.Errorf("%v", r)
+}
+
+// errorMessage returns the message of the error with the repository and tag the run was working on
+func errorMessage(err error) string {
+	msg := err.Error()
+	ctx := contextOf(err)
+	var context []string
+	if ctx.Phase != "" {
+		context = append(context, "phase "+ctx.Phase)
+	}
+	if ctx.Repository != "" {
+		ref := ctx.Repository
+		if ctx.Tag != "" {
+			ref += ":" + ctx.Tag
+		}
+		if !strings.Contains(msg, ref) {
+			context = append(context, "while working on "+ref)
+		}
+	}
+	if len(context) > 0 {
+		msg += " (" + strings.Join(context, ", ") + ")"
+	}
+	return msg
+}
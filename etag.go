@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// etagTransport keeps tag lists and manifests with their ETag in a directory and asks the
+// registry with If-None-Match whether they changed. Unchanged responses are answered with
+// 304 Not Modified and no body, the saved response is returned instead.
+type etagTransport struct {
+	dir  string
+	next http.RoundTripper
+}
+
+func (e *etagTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != "GET" || !(strings.HasSuffix(req.URL.Path, "/tags/list") || strings.Contains(req.URL.Path, "/manifests/")) {
+		return e.next.RoundTrip(req)
+	}
+
+	// Send the ETag of the saved response
+	file := recordingFile(e.dir, req)
+	var saved *Recording
+	if data, err := ioutil.ReadFile(file); err == nil {
+		saved = &Recording{}
+		if json.Unmarshal(data, saved) != nil || saved.Header.Get("ETag") == "" {
+			saved = nil
+		}
+	}
+	if saved != nil {
+		req = req.Clone(req.Context())
+		req.Header.Set("If-None-Match", saved.Header.Get("ETag"))
+	}
+	resp, err := e.next.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	if resp.StatusCode == http.StatusNotModified && saved != nil {
+		resp.Body.Close()
+		return saved.response(req), nil
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("ETag") == "" {
+		return resp, nil
+	}
+
+	// Save the response with its ETag
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	data, err := json.Marshal(Recording{
+		Method: req.Method,
+		URL:    req.URL.String(),
+		Status: resp.StatusCode,
+		Header: resp.Header,
+		Body:   body,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(file, data, 0600); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
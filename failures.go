@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"text/tabwriter"
+)
+
+// Failure is an operation which failed without aborting the run
+type Failure struct {
+	Operation  string
+	Repository string
+	Tag        string
+	Error      string
+}
+
+// Failures of the run, printed at the end
+var failures struct {
+	list []Failure
+	sync.Mutex
+}
+
+// recordFailure remembers the failure for the summary, counts it for the exit code
+// and reports it with the context it happened in
+func recordFailure(operation string, ctx ErrorContext, err error) {
+	failures.Lock()
+	failures.list = append(failures.list, Failure{operation, ctx.Repository, ctx.Tag, err.Error()})
+	failedCount++
+	failures.Unlock()
+	reportError(err, ctx)
+}
+
+// tolerate calls fn and records its error as failure of the operation, the run continues with
+// the next image or repository. Returns false if fn failed. With -fail-fast the error is returned
+// and aborts the run.
+func tolerate(operation, repository, tag string, fn func() error) (bool, error) {
+	err := fn()
+	if err == nil {
+		return true, nil
+	}
+	if Cfg.FailFast {
+		return false, withContext(err, repository, tag)
+	}
+	ref := repository
+	if tag != "" {
+		ref += ":" + tag
+	}
+	fmt.Fprintln(os.Stderr, colorize(colorBoldRed, fmt.Sprintf("Cannot %s %s: %v", operation, ref, err)))
+	ctx := newErrorContext(repository, tag)
+	if inner := workerContext(err); inner != nil {
+		// A worker of the operation knows the failed image
+		ctx = *inner
+	}
+	recordFailure(operation, ctx, err)
+	return false, nil
+}
+
+// withoutFailed removes the images whose manifest couldn't be fetched from the images to delete,
+// their age is unknown
+func withoutFailed(images []*Image) []*Image {
+	var result []*Image
+	for _, image := range images {
+		if image.Result != "failed" {
+			result = append(result, image)
+		}
+	}
+	return result
+}
+
+// printFailures prints the failures of the run as table
+func printFailures() {
+	failures.Lock()
+	defer failures.Unlock()
+	if len(failures.list) == 0 {
+		return
+	}
+	fmt.Printf("%d failures:\n", len(failures.list))
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "  OPERATION\tREPOSITORY\tTAG\tERROR")
+	for _, f := range failures.list {
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%s\n", f.Operation, f.Repository, f.Tag, f.Error)
+	}
+	w.Flush()
+}
+
+// resetFailures forgets the failures of the previous scheduled run
+func resetFailures() {
+	failures.Lock()
+	defer failures.Unlock()
+	failures.list = nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// collectGarbage explains that deleted manifests don't free disk space by themselves and
+// starts the registry garbage collection with the -gc-command or -gc-webhook if given
+func collectGarbage() {
+	if Cfg.Trash {
+		fmt.Println("The blobs are still referenced by the trash repositories and are freed after purge-trash.")
+	}
+	if Cfg.GCCommand == "" && Cfg.GCWebhook == "" {
+		fmt.Println("Blobs stay on disk until the registry garbage collection runs, e.g. sudo gitlab-ctl registry-garbage-collect -m. " +
+			"Registries with the metadata database collect garbage online. Use -gc-command or -gc-webhook to start it after pruning.")
+		return
+	}
+	defer timePhase("garbage collection")()
+
+	// Run the command with the number of deleted images in the environment
+	if Cfg.GCCommand != "" {
+		fmt.Println("Starting registry garbage collection:", Cfg.GCCommand)
+		cmd := exec.Command("sh", "-c", Cfg.GCCommand)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		cmd.Env = append(os.Environ(), fmt.Sprintf("PRUNER_DELETED_IMAGES=%d", deletedCount))
+		if err := cmd.Run(); err != nil {
+			fmt.Println("Registry garbage collection failed:", err.Error())
+			failedCount++
+		}
+	}
+
+	// Post the summary to the webhook
+	if Cfg.GCWebhook != "" {
+		sendNotification("garbage collection webhook", func() error {
+			return postJSON(Cfg.GCWebhook, runSummary())
+		})
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const (
+	protectionTagRulesURL = "%s/api/v4/projects/%s/registry/protection/tag/rules"
+	graphQLURL            = "%s/api/graphql"
+	pipelinesURL          = "%s/api/v4/projects/%s/pipelines?scope=%s&per_page=100"
+	issuesURL             = "%s/api/v4/projects/%s/issues"
+	issueSearchURL        = "%s/api/v4/projects/%s/issues?state=opened&in=title&search=%s"
+	issueNotesURL         = "%s/api/v4/projects/%s/issues/%d/notes"
+)
+
+// sendGitlabRequest sends a request to the GitLab API and returns the body and response.
+// The password is used as personal access token.
+func sendGitlabRequest(apiURL, method string, data []byte) ([]byte, *http.Response, error) {
+	if apiToken() == "" && Cfg.JobToken == "" && Cfg.DeployToken != "" {
+		return nil, nil, errNoAPIToken
+	}
+	req, err := http.NewRequest(method, apiURL, bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, err
+	}
+	setGitlabAuth(req)
+	if data != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	// Send request
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	// Get response from body
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return body, resp, nil
+}
+
+// getProtectedTagPatterns returns the tag name patterns of the project's protected container tag rules.
+// If the rules can't be fetched the repository is aborted when deleting, dry runs only warn.
+func getProtectedTagPatterns(repo *Repository) ([]*regexp.Regexp, error) {
+	// Create request
+	var body []byte
+	var resp *http.Response
+	id, err := projectID(repo)
+	if err == nil {
+		body, resp, err = sendGitlabRequest(fmt.Sprintf(protectionTagRulesURL, Cfg.GitlabURL, id), "GET", nil)
+	}
+	if err == nil && resp.StatusCode == http.StatusNotFound {
+		// Older GitLab versions don't know this endpoint and have no rules
+		return nil, nil
+	}
+
+	// Validate response
+	if err == nil && resp.StatusCode != http.StatusOK {
+		err = newResponseError(resp, body)
+	}
+	if err != nil {
+		if Cfg.DeleteImages {
+			return nil, fmt.Errorf("cannot get protected tag rules, use -protected-tags=false to ignore them: %w", err)
+		}
+		fmt.Printf("Cannot get protected tag rules: %s\n", err.Error())
+		return nil, nil
+	}
+
+	// Extract rules from response
+	var rules []map[string]interface{}
+	if err := json.Unmarshal(body, &rules); err != nil {
+		return nil, fmt.Errorf("invalid protected tag rules: %w", err)
+	}
+
+	// Compile tag name patterns
+	var patterns []*regexp.Regexp
+	for _, rule := range rules {
+		pattern, ok := rule["tag_name_pattern"].(string)
+		if !ok {
+			continue
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			fmt.Printf("Invalid protected tag pattern %s: %s\n", pattern, err.Error())
+			continue
+		}
+		patterns = append(patterns, re)
+	}
+	return patterns, nil
+}
+
+// sendGitlabGraphQL sends a GraphQL query and decodes the data of the response into result
+func sendGitlabGraphQL(query string, variables map[string]interface{}, result interface{}) error {
+	// Create request
+	data, err := json.Marshal(map[string]interface{}{"query": query, "variables": variables})
+	if err != nil {
+		return err
+	}
+	body, resp, err := sendGitlabRequest(fmt.Sprintf(graphQLURL, Cfg.GitlabURL), "POST", data)
+	if err != nil {
+		return err
+	}
+
+	// Validate response
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("graphql request failed with return code %d: %s", resp.StatusCode, string(body[:]))
+	}
+
+	// Extract data from response
+	var graphQLResp struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := json.Unmarshal(body, &graphQLResp); err != nil {
+		return err
+	}
+	if len(graphQLResp.Errors) > 0 {
+		return fmt.Errorf("graphql request failed: %s", graphQLResp.Errors[0].Message)
+	}
+	return json.Unmarshal(graphQLResp.Data, result)
+}
+
+// getPipelineTagHints returns the commit shas, short shas and ref slugs of the project's running and pending pipelines.
+// If they can't be fetched the repository fails when deleting, their images could be deleted otherwise. Dry runs only warn.
+func getPipelineTagHints(repo *Repository) ([]string, error) {
+	hints, err := pipelineTagHints(repo)
+	if err != nil {
+		if Cfg.DeleteImages {
+			return nil, fmt.Errorf("cannot get running pipelines, use -running-pipelines=false to ignore them: %w", err)
+		}
+		fmt.Printf("Cannot get running pipelines: %s\n", err.Error())
+		return nil, nil
+	}
+	return hints, nil
+}
+
+// pipelineTagHints returns the commit SHAs and ref slugs of the running and pending pipelines of the project
+func pipelineTagHints(repo *Repository) ([]string, error) {
+	id, err := projectID(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	var hints []string
+	for _, scope := range []string{"running", "pending"} {
+		// Create request
+		body, resp, err := sendGitlabRequest(fmt.Sprintf(pipelinesURL, Cfg.GitlabURL, id, scope), "GET", nil)
+		if err != nil {
+			return nil, err
+		}
+
+		// Validate response
+		if resp.StatusCode != http.StatusOK {
+			return nil, newResponseError(resp, body)
+		}
+
+		// Extract pipelines from response
+		var pipelines []map[string]interface{}
+		if err := json.Unmarshal(body, &pipelines); err != nil {
+			return nil, fmt.Errorf("invalid %s pipelines: %w", scope, err)
+		}
+		for _, pipeline := range pipelines {
+			if sha, ok := pipeline["sha"].(string); ok && len(sha) >= 8 {
+				hints = append(hints, sha, sha[:8])
+			}
+			if ref, ok := pipeline["ref"].(string); ok {
+				hints = append(hints, refSlug(ref))
+			}
+		}
+	}
+	return hints, nil
+}
+
+// refSlug returns the ref as CI_COMMIT_REF_SLUG: lowercased, shortened to 63 bytes and
+// everything except 0-9 and a-z replaced with -
+func refSlug(ref string) string {
+	slug := regexp.MustCompile(`[^a-z0-9]`).ReplaceAllString(strings.ToLower(ref), "-")
+	if len(slug) > 63 {
+		slug = slug[:63]
+	}
+	return strings.Trim(slug, "-")
+}
+
+// usedByPipeline returns true if the tag is the ref slug or contains the commit sha of a pipeline
+func usedByPipeline(tag string, hints []string) bool {
+	for _, hint := range hints {
+		if tag == hint || (len(hint) >= 8 && strings.Contains(tag, hint)) {
+			return true
+		}
+	}
+	return false
+}
+
+// reportIssue appends the deleted images to the open issue with the -issue-title in the
+// -issue-project or creates the issue if there is none
+func reportIssue(s Summary) error {
+	project := url.PathEscape(Cfg.IssueProject)
+
+	// Create report
+	var report strings.Builder
+	fmt.Fprintf(&report, "%s\n\n| Image | Digest |\n|---|---|\n", s.text())
+	for _, repo := range scannedRepositories {
+		for _, image := range repo.Images {
+			if image.decision() == "deleted" {
+				fmt.Fprintf(&report, "| `%s:%s` | `%s` |\n", image.Name, image.Tag, image.Digest)
+			}
+		}
+	}
+
+	// Find open issue
+	body, resp, err := sendGitlabRequest(fmt.Sprintf(issueSearchURL, Cfg.GitlabURL, project, url.QueryEscape(Cfg.IssueTitle)), "GET", nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("cannot search issues, return code %d", resp.StatusCode)
+	}
+	var issues []struct {
+		IID   int    `json:"iid"`
+		Title string `json:"title"`
+	}
+	if err := json.Unmarshal(body, &issues); err != nil {
+		return err
+	}
+
+	// Append to the issue or create a new one
+	apiURL := fmt.Sprintf(issuesURL, Cfg.GitlabURL, project)
+	data := map[string]string{"title": Cfg.IssueTitle, "description": report.String()}
+	for _, issue := range issues {
+		if issue.Title == Cfg.IssueTitle {
+			apiURL = fmt.Sprintf(issueNotesURL, Cfg.GitlabURL, project, issue.IID)
+			data = map[string]string{"body": report.String()}
+			break
+		}
+	}
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, resp, err = sendGitlabRequest(apiURL, "POST", payload)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("cannot write issue, return code %d", resp.StatusCode)
+	}
+	return nil
+}
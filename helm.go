@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// isHelmChart returns true if the image is a Helm chart OCI artifact
+func isHelmChart(image *Image) bool {
+	return image.ConfigType == helmConfigMediaType
+}
+
+// splitHelmCharts separates helm charts from container images
+func splitHelmCharts(images []*Image) ([]*Image, []*Image) {
+	var charts []*Image
+	i := 0
+	for _, image := range images {
+		if isHelmChart(image) {
+			charts = append(charts, image)
+		} else {
+			// This image should stay in slice
+			images[i] = image
+			i++
+		}
+	}
+	return images[:i], charts
+}
+
+// expireHelmCharts returns the charts which are older than the chart expiry and not deployed
+func expireHelmCharts(charts []*Image) ([]*Image, error) {
+	if len(charts) == 0 {
+		return nil, nil
+	}
+	if Cfg.ChartMinExpiry < 0 {
+		fmt.Printf("Skipped %d helm charts, use -chart-minexpiry to prune them\n", len(charts))
+		for _, chart := range charts {
+			chart.Reason = "helm chart"
+		}
+		return nil, nil
+	}
+
+	// Get deployed chart versions
+	var releases map[string]bool
+	if Cfg.ChartReleases {
+		var err error
+		if releases, err = getHelmReleases(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Calculate chart expiry date
+	expiryDate := time.Now().AddDate(0, 0, Cfg.ChartMinExpiry*-1)
+
+	var expired []*Image
+	for _, chart := range charts {
+		switch {
+		case chart.Created.IsZero():
+			logf(lineKept, "Helm chart %s:%s has no creation date, skipped\n", chart.Name, chart.Tag)
+			chart.Reason = "helm chart without creation date"
+		case !chart.Created.Before(expiryDate):
+			logf(lineKept, "Helm chart %s:%s is too young, skipped: %s\n", chart.Name, chart.Tag, chart.Created.String())
+			chart.Reason = "helm chart too young"
+		case releases[path.Base(chart.Name)+":"+chart.Tag]:
+			logf(lineKept, "Helm chart %s:%s is deployed, skipped\n", chart.Name, chart.Tag)
+			chart.Reason = "helm chart deployed"
+		default:
+			expired = append(expired, chart)
+		}
+	}
+	return expired, nil
+}
+
+// getHelmReleases returns the deployed chart name:version pairs of all clusters
+func getHelmReleases() (map[string]bool, error) {
+	releases := make(map[string]bool)
+	var mutex sync.Mutex
+
+	// Scan all clusters at once, errors are passed on to the caller
+	clusters, err := getClusters()
+	if err != nil {
+		return nil, err
+	}
+	err = forEach(len(clusters), len(clusters), func(i int) error {
+		clusterReleases, err := getClusterHelmReleases(clusters[i])
+		if err != nil {
+			return fmt.Errorf("cannot list Helm releases of cluster %s: %w", clusters[i].Name, err)
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		for _, release := range clusterReleases {
+			releases[release] = true
+		}
+		return nil
+	})
+	return releases, err
+}
+
+func getClusterHelmReleases(cluster Cluster) ([]string, error) {
+	clientset, err := kubernetes.NewForConfig(cluster.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	// Helm 3 stores every release revision as secret in all namespaces
+	secrets, err := clientset.CoreV1Client.Secrets("").List(v1.ListOptions{LabelSelector: "owner=helm"})
+	if err != nil {
+		return nil, err
+	}
+
+	var releases []string
+	for _, secret := range secrets.Items {
+		name, version, err := decodeHelmRelease(secret.Data["release"])
+		if err != nil {
+			fmt.Printf("Cannot decode helm release %s/%s: %s\n", secret.Namespace, secret.Name, err.Error())
+			continue
+		}
+		releases = append(releases, name+":"+version)
+	}
+	return releases, nil
+}
+
+// decodeHelmRelease returns chart name and version of a helm release (base64 encoded, gzipped json)
+func decodeHelmRelease(data []byte) (string, string, error) {
+	decoded, err := base64.StdEncoding.DecodeString(string(data))
+	if err != nil {
+		return "", "", err
+	}
+
+	// Releases are gzipped by default
+	if bytes.HasPrefix(decoded, []byte{0x1f, 0x8b}) {
+		r, err := gzip.NewReader(bytes.NewReader(decoded))
+		if err != nil {
+			return "", "", err
+		}
+		if decoded, err = ioutil.ReadAll(r); err != nil {
+			return "", "", err
+		}
+	}
+
+	// Extract chart metadata
+	var release struct {
+		Chart struct {
+			Metadata struct {
+				Name    string `json:"name"`
+				Version string `json:"version"`
+			} `json:"metadata"`
+		} `json:"chart"`
+	}
+	if err := json.Unmarshal(decoded, &release); err != nil {
+		return "", "", err
+	}
+	return release.Chart.Metadata.Name, release.Chart.Metadata.Version, nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const historySchema = `
+CREATE TABLE IF NOT EXISTS runs (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	started TEXT NOT NULL,
+	finished TEXT NOT NULL,
+	actor TEXT NOT NULL,
+	dry_run INTEGER NOT NULL,
+	repositories INTEGER NOT NULL,
+	tags_scanned INTEGER NOT NULL,
+	tags_deleted INTEGER NOT NULL,
+	bytes_reclaimed INTEGER NOT NULL,
+	failures INTEGER NOT NULL,
+	error TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS images (
+	run_id INTEGER NOT NULL REFERENCES runs(id),
+	repository TEXT NOT NULL,
+	tag TEXT NOT NULL,
+	digest TEXT NOT NULL,
+	created TEXT NOT NULL,
+	size INTEGER NOT NULL,
+	decision TEXT NOT NULL,
+	reason TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS images_tag ON images (repository, tag);
+`
+
+// openHistory opens the history database and creates the tables if needed
+func openHistory(file string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", file)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(historySchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// saveHistory stores the run with all scanned images and their decisions
+func saveHistory(s Summary) error {
+	db, err := openHistory(Cfg.HistoryDB)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Insert run
+	result, err := tx.Exec(`INSERT INTO runs (started, finished, actor, dry_run, repositories, tags_scanned, tags_deleted, bytes_reclaimed, failures, error)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		startTime.UTC().Format(time.RFC3339), s.Finished.UTC().Format(time.RFC3339), auditActor(), s.DryRun,
+		s.Repositories, s.TagsScanned, s.TagsDeleted, s.BytesReclaimed, s.Failures, s.Error)
+	if err != nil {
+		return err
+	}
+	runID, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	// Insert images
+	stmt, err := tx.Prepare(`INSERT INTO images (run_id, repository, tag, digest, created, size, decision, reason) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, repo := range scannedRepositories {
+		for _, image := range repo.Images {
+			var created string
+			if !image.Created.IsZero() {
+				created = image.Created.UTC().Format(time.RFC3339)
+			}
+			if _, err := stmt.Exec(runID, image.Name, image.Tag, image.Digest, created, image.Size, image.decision(), image.Reason); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// history prints the past runs or the decisions of past runs for a repository or tag
+func history(args []string) error {
+	fs := flag.NewFlagSet("history", flag.ContinueOnError)
+	all := fs.Bool("all", false, "Also print kept decisions, not only deletions")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: gitlab-registry-pruner -history-db file history [-all] [repository[:tag]]")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if Cfg.HistoryDB == "" || fs.NArg() > 1 {
+		fs.Usage()
+		return errUsage
+	}
+
+	db, err := openHistory(Cfg.HistoryDB)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// List runs
+	if fs.NArg() == 0 {
+		rows, err := db.Query(`SELECT id, started, actor, dry_run, repositories, tags_scanned, tags_deleted, bytes_reclaimed, failures, error FROM runs ORDER BY id`)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id, repositories, scanned, deleted, failures int
+			var started, actor, errorMessage string
+			var dryRun bool
+			var reclaimed int64
+			if err := rows.Scan(&id, &started, &actor, &dryRun, &repositories, &scanned, &deleted, &reclaimed, &failures, &errorMessage); err != nil {
+				return err
+			}
+			mode := "run"
+			if dryRun {
+				mode = "dry run"
+			}
+			fmt.Printf("#%d %s %s by %s: %d repositories, %d tags scanned, %d deleted, %s, %d failures %s\n",
+				id, started, mode, actor, repositories, scanned, deleted, formatBytes(reclaimed), failures, errorMessage)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		return nil
+	}
+
+	// List decisions of the repository or tag
+	query := `SELECT runs.id, runs.started, runs.dry_run, images.repository, images.tag, images.digest, images.decision, images.reason
+		FROM images JOIN runs ON runs.id = images.run_id WHERE images.repository = ?`
+	repository, tag := fs.Arg(0), ""
+	if i := strings.LastIndex(fs.Arg(0), ":"); i > 0 {
+		repository, tag = fs.Arg(0)[:i], fs.Arg(0)[i+1:]
+	}
+	params := []interface{}{repositoryName(repository)}
+	if tag != "" {
+		query += " AND images.tag = ?"
+		params = append(params, tag)
+	}
+	if !*all {
+		query += " AND images.decision != 'keep'"
+	}
+	rows, err := db.Query(query+" ORDER BY runs.id, images.tag", params...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var started, repo, imageTag, digest, decision, reason string
+		var dryRun bool
+		if err := rows.Scan(&id, &started, &dryRun, &repo, &imageTag, &digest, &decision, &reason); err != nil {
+			return err
+		}
+		if dryRun {
+			decision += " (dry run)"
+		}
+		fmt.Printf("#%d %s %s:%s %s %s %s\n", id, started, repo, imageTag, decision, digest, reason)
+	}
+	return rows.Err()
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"html/template"
+	"sort"
+	"strings"
+	"time"
+)
+
+// htmlReportTemplate is a standalone page with sortable tables. Clicking a column header sorts by it.
+var htmlReportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"bytes": formatBytes,
+	"date": func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format("2006-01-02 15:04")
+	},
+	"decision": func(image *Image) string {
+		return image.decision()
+	},
+	"join": strings.Join,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; width: 100%; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+th { background: #eee; cursor: pointer; }
+tr.delete td, tr.deleted td { background: #fdd; }
+tr.failed td { background: #fd9; }
+td.num { text-align: right; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>Generated {{date .Created}}</p>
+
+<h2>Repositories</h2>
+{{range .Repositories}}
+<h3>{{.Name}}</h3>
+<table class="sortable">
+<thead><tr><th>Tag</th><th>Digest</th><th>Created</th><th>Size</th><th>Decision</th><th>Reason</th><th>Clusters</th></tr></thead>
+<tbody>
+{{range .Images}}<tr class="{{decision .}}"><td>{{.Tag}}</td><td>{{.Digest}}</td><td>{{date .Created}}</td><td class="num" data-value="{{.Size}}">{{bytes .Size}}</td><td>{{decision .}}</td><td>{{.Reason}}</td><td>{{join .Clusters ", "}}</td></tr>
+{{end}}</tbody>
+</table>
+{{end}}
+
+<h2>Clusters</h2>
+{{range .Clusters}}
+<h3>{{.Name}}</h3>
+<table class="sortable">
+<thead><tr><th>Image</th><th>Created</th><th>Size</th></tr></thead>
+<tbody>
+{{range .Images}}<tr><td>{{.Name}}:{{.Tag}}</td><td>{{date .Created}}</td><td class="num" data-value="{{.Size}}">{{bytes .Size}}</td></tr>
+{{end}}</tbody>
+</table>
+{{else}}
+<p>No images are used in a cluster.</p>
+{{end}}
+
+<script>
+document.querySelectorAll("table.sortable th").forEach(function (th) {
+	th.addEventListener("click", function () {
+		var table = th.closest("table"), body = table.tBodies[0];
+		var column = Array.prototype.indexOf.call(th.parentNode.children, th);
+		var asc = th.dataset.order !== "asc";
+		th.dataset.order = asc ? "asc" : "desc";
+		var value = function (row) {
+			var cell = row.children[column];
+			return cell.dataset.value !== undefined ? Number(cell.dataset.value) : cell.textContent;
+		};
+		Array.from(body.rows).sort(function (a, b) {
+			var x = value(a), y = value(b);
+			return (x > y ? 1 : x < y ? -1 : 0) * (asc ? 1 : -1);
+		}).forEach(function (row) { body.appendChild(row); });
+	});
+});
+</script>
+</body>
+</html>
+`))
+
+// htmlReportCluster lists the images used in a cluster
+type htmlReportCluster struct {
+	Name   string
+	Images []*Image
+}
+
+// writeHTMLReport writes a standalone HTML page with all decisions per repository and per cluster
+func writeHTMLReport() error {
+	title := "Registry prune report"
+	if !Cfg.DeleteImages {
+		title += " (dry run)"
+	}
+
+	// Group images by cluster
+	byCluster := make(map[string]*htmlReportCluster)
+	for _, repo := range scannedRepositories {
+		for _, image := range repo.Images {
+			for _, name := range image.Clusters {
+				if byCluster[name] == nil {
+					byCluster[name] = &htmlReportCluster{Name: name}
+				}
+				byCluster[name].Images = append(byCluster[name].Images, image)
+			}
+		}
+	}
+	var clusters []*htmlReportCluster
+	for _, cluster := range byCluster {
+		clusters = append(clusters, cluster)
+	}
+	sort.Slice(clusters, func(a, b int) bool {
+		return clusters[a].Name < clusters[b].Name
+	})
+
+	w, err := createFile(Cfg.ReportHTML)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	return htmlReportTemplate.Execute(w, map[string]interface{}{
+		"Title":        title,
+		"Created":      time.Now(),
+		"Repositories": scannedRepositories,
+		"Clusters":     clusters,
+	})
+}
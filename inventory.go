@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Inventory is a snapshot of all tags of the registry
+type Inventory struct {
+	Created  time.Time        `json:"created"`
+	Registry string           `json:"registry"`
+	Images   []InventoryImage `json:"images"`
+}
+
+// InventoryImage is a tag of the inventory
+type InventoryImage struct {
+	Repository   string       `json:"repository"`
+	Tag          string       `json:"tag"`
+	Digest       string       `json:"digest"`
+	Created      time.Time    `json:"created"`
+	Size         int64        `json:"size"`
+	MediaType    string       `json:"media_type"`
+	ArtifactType string       `json:"artifact_type,omitempty"`
+	ConfigType   string       `json:"config_type,omitempty"`
+	Clusters     []string     `json:"clusters"`
+	Blobs        []Descriptor `json:"blobs,omitempty"`
+	Children     []string     `json:"children,omitempty"`
+}
+
+// inventoryImage returns the details of the image
+func inventoryImage(image *Image) InventoryImage {
+	return InventoryImage{
+		Repository:   image.Name,
+		Tag:          image.Tag,
+		Digest:       image.Digest,
+		Created:      image.Created,
+		Size:         image.Size,
+		MediaType:    image.MediaType,
+		ArtifactType: image.ArtifactType,
+		ConfigType:   image.ConfigType,
+		Clusters:     image.Clusters,
+		Blobs:        image.Blobs,
+		Children:     image.Children,
+	}
+}
+
+// image returns the image of the repository with the details
+func (i InventoryImage) image(repo *Repository) *Image {
+	image := &Image{
+		Name:         repo.Name,
+		Tag:          i.Tag,
+		Repository:   repo,
+		Digest:       i.Digest,
+		Created:      i.Created,
+		Size:         i.Size,
+		MediaType:    i.MediaType,
+		ArtifactType: i.ArtifactType,
+		ConfigType:   i.ConfigType,
+		Blobs:        i.Blobs,
+		Children:     i.Children,
+	}
+	for _, cluster := range i.Clusters {
+		image.setUsedIn(cluster)
+	}
+	return image
+}
+
+// offlineInventory replaces the registry for -from-inventory runs
+var offlineInventory *Inventory
+
+// inventory writes all tags with digest, creation date, size and cluster usage to a file without pruning
+func inventory(args []string) error {
+	if len(args) > 0 && args[0] == "diff" {
+		return inventoryDiff(args[1:])
+	}
+
+	fs := flag.NewFlagSet("inventory", flag.ContinueOnError)
+	out := fs.String("out", "-", "File the inventory is written to, - writes to stdout")
+	format := fs.String("format", "json", "Format of the inventory: json or csv")
+	parseFlags(fs, args)
+	if *format != "json" && *format != "csv" {
+		return fmt.Errorf("unknown inventory format %q", *format)
+	}
+
+	// --- Get all images with their details ---
+	images, err := getAllImages()
+	if err != nil {
+		return err
+	}
+
+	// --- Look up images in clusters ---
+	if err := scanClusters(images); err != nil {
+		return err
+	}
+
+	// --- Write inventory ---
+	inv := Inventory{Created: time.Now(), Registry: Cfg.RegistryURL, Images: []InventoryImage{}}
+	for _, image := range images {
+		inv.Images = append(inv.Images, inventoryImage(image))
+	}
+	w, err := createFile(*out)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	if *format == "csv" {
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"repository", "tag", "digest", "created", "size", "media_type", "artifact_type", "config_type", "clusters"})
+		for _, image := range inv.Images {
+			cw.Write([]string{image.Repository, image.Tag, image.Digest, image.Created.Format(time.RFC3339),
+				strconv.FormatInt(image.Size, 10), image.MediaType, image.ArtifactType, image.ConfigType, strings.Join(image.Clusters, ";")})
+		}
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return err
+		}
+	} else {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(inv); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Inventory of %d images in %d repositories written\n", len(inv.Images), len(Cfg.Repositories))
+	printFailures()
+	return nil
+}
+
+// getAllImages returns all images of the repositories with digest, creation date, media type and size
+func getAllImages() ([]*Image, error) {
+	if err := prepareRepositories(); err != nil {
+		return nil, err
+	}
+	var images []*Image
+	for _, path := range Cfg.Repositories {
+		repo := newRepository(path)
+		if offlineInventory != nil {
+			images = append(images, offlineInventory.images(repo)...)
+			continue
+		}
+		token, err := getRegistryToken(repo)
+		if err != nil {
+			return nil, withContext(err, repo.Name, "")
+		}
+		repo.Token = token
+		repoImages, err := getImages(repo)
+		if err != nil {
+			return nil, withContext(err, repo.Name, "")
+		}
+		if err := setImageUploadDate(repo, repoImages); err != nil {
+			return nil, err
+		}
+
+		// Images of failed manifests would look like the oldest ones offline
+		repoImages = withoutFailed(repoImages)
+		if err := setImageMediaType(repoImages); err != nil {
+			return nil, err
+		}
+		images = append(images, repoImages...)
+	}
+	if offlineInventory == nil {
+		if err := setImageDigest(images); err != nil {
+			return nil, err
+		}
+	}
+	return images, nil
+}
+
+// loadInventory reads an inventory written as JSON or, for files ending with .csv, as CSV
+func loadInventory(file string) (*Inventory, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	inv := &Inventory{}
+	if !strings.HasSuffix(file, ".csv") {
+		if err := json.Unmarshal(data, inv); err != nil {
+			return nil, fmt.Errorf("invalid inventory %s: %w", file, err)
+		}
+		return inv, nil
+	}
+
+	// Parse CSV, the first line is the header
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid inventory %s: %w", file, err)
+	}
+	for i, record := range records {
+		if i == 0 {
+			continue
+		}
+		if len(record) != 9 {
+			return nil, fmt.Errorf("invalid inventory %s: line %d has %d fields", file, i+1, len(record))
+		}
+		image := InventoryImage{Repository: record[0], Tag: record[1], Digest: record[2], MediaType: record[5], ArtifactType: record[6], ConfigType: record[7]}
+		image.Created, _ = time.Parse(time.RFC3339, record[3])
+		image.Size, _ = strconv.ParseInt(record[4], 10, 64)
+		if record[8] != "" {
+			image.Clusters = strings.Split(record[8], ";")
+		}
+		inv.Images = append(inv.Images, image)
+	}
+	return inv, nil
+}
+
+// repositories returns the repositories of the inventory matching the patterns, all if none are given
+func (inv *Inventory) repositories(patterns []string) ([]string, error) {
+	var repositories []string
+	seen := make(map[string]bool)
+	for _, image := range inv.Images {
+		if seen[image.Repository] {
+			continue
+		}
+		for _, pattern := range patterns {
+			if ok, err := path.Match(repositoryName(pattern), repositoryName(image.Repository)); err != nil {
+				return nil, err
+			} else if ok {
+				seen[image.Repository] = true
+				break
+			}
+		}
+		if len(patterns) == 0 {
+			seen[image.Repository] = true
+		}
+		if seen[image.Repository] {
+			repositories = append(repositories, image.Repository)
+		}
+	}
+	return repositories, nil
+}
+
+// images returns the images of the repository from the inventory like getImages
+func (inv *Inventory) images(repo *Repository) []*Image {
+	var images []*Image
+	for _, i := range inv.Images {
+		if repositoryName(i.Repository) != repositoryName(repo.Name) {
+			continue
+		}
+		images = append(images, i.image(repo))
+	}
+
+	// Remember all images for the results
+	repo.TagCount = len(images)
+	repo.Images = append([]*Image{}, images...)
+	scannedRepositories = append(scannedRepositories, repo)
+	return images
+}
+
+// inventoryDiff prints the tags added, removed and changed between two inventories
+func inventoryDiff(args []string) error {
+	fs := flag.NewFlagSet("inventory diff", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: gitlab-registry-pruner inventory diff old-inventory new-inventory")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return errUsage
+	}
+	oldInv, err := loadInventory(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	newInv, err := loadInventory(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	// Index tags by repository and tag
+	index := func(inv *Inventory) map[string]InventoryImage {
+		images := make(map[string]InventoryImage)
+		for _, image := range inv.Images {
+			images[image.Repository+":"+image.Tag] = image
+		}
+		return images
+	}
+	oldImages, newImages := index(oldInv), index(newInv)
+	var names []string
+	for name := range oldImages {
+		names = append(names, name)
+	}
+	for name := range newImages {
+		if _, ok := oldImages[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	// Print differences
+	var added, removed, changed int
+	for _, name := range names {
+		oldImage, inOld := oldImages[name]
+		newImage, inNew := newImages[name]
+		switch {
+		case !inOld:
+			added++
+			fmt.Println(colorize(colorGreen, fmt.Sprintf("+ %s %s", name, newImage.Digest)))
+		case !inNew:
+			removed++
+			fmt.Println(colorize(colorRed, fmt.Sprintf("- %s %s", name, oldImage.Digest)))
+		case oldImage.Digest != newImage.Digest:
+			changed++
+			fmt.Println(colorize(colorYellow, fmt.Sprintf("~ %s %s -> %s", name, oldImage.Digest, newImage.Digest)))
+		}
+	}
+	fmt.Printf("%d added, %d removed, %d changed\n", added, removed, changed)
+	return nil
+}
@@ -3,10 +3,13 @@ package main
 import (
 	"bufio"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
 	"regexp"
 	"strings"
@@ -15,21 +18,143 @@ import (
 
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/pkg/api/v1"
-	"k8s.io/client-go/tools/clientcmd"
 )
 
 // Config represents the configuration
 type Config struct {
-	GitlabURL        string
-	RegistryURL      string
-	RegistryURLShort string
-	Username         string
-	Password         string
-	Repository       string
-	KubeConfig       kubeConfigFlags
-	MinExpiry        int
-	RegexPattern     string
-	DeleteImages     bool
+	ConfigFile            string
+	GitlabURL             string
+	RegistryURL           string
+	RegistryURLShort      string
+	Username              string
+	Password              string
+	Token                 string
+	DeployTokenUser       string
+	DeployToken           string
+	JobToken              string
+	CredentialsSecret     string
+	UseDockerConfig       bool
+	VaultAddr             string
+	VaultPath             string
+	VaultToken            string
+	VaultRoleID           string
+	Repositories          repositoryFlags
+	RepositoryPolicies    []*RepositoryPolicy
+	Registries            []*RegistryConfig
+	Catalog               bool
+	ProjectID             string
+	KubeConfig            kubeConfigFlags
+	AgentProjects         agentProjectFlags
+	ECSClusters           ecsClusterFlags
+	ECSRegion             string
+	MinExpiry             int
+	RegexPatterns         regexpFlags
+	DeleteRegexPatterns   regexpFlags
+	ProtectFile           string
+	DeleteFile            string
+	TagsFrom              string
+	FromInventory         string
+	TombstoneFile         string
+	GracePeriod           int
+	MaxDeletions          int
+	TargetSize            byteSize
+	Strategy              string
+	TruncateDeletions     bool
+	Stream                bool
+	Checkpoint            string
+	Resume                bool
+	MaxDeletePercent      int
+	Force                 bool
+	DeleteImages          bool
+	Trash                 bool
+	DeleteReferrers       bool
+	GCCommand             string
+	GCWebhook             string
+	RetentionTiers        retentionTiers
+	KeepLatest            int
+	Signatures            string
+	KeepPercent           int
+	TagPolicies           tagPolicies
+	SemverPatches         int
+	SemverMajors          int
+	CacheMinExpiry        int
+	CacheTagPattern       regexpFlag
+	ChartMinExpiry        int
+	ChartReleases         bool
+	ArtifactTypes         artifactTypes
+	DropPlatforms         platformFlags
+	AllowProtected        bool
+	ProtectedTags         bool
+	SignedKeys            signerFlags
+	SignedIdentities      signerFlags
+	SignedRoots           string
+	RunningPipelines      bool
+	DependencyProxyGroup  string
+	PackageTypes          string
+	Yes                   bool
+	Review                bool
+	PromptTimeout         time.Duration
+	Output                string
+	OutputFile            string
+	Report                string
+	ReportFile            string
+	ReportHTML            string
+	Quiet                 bool
+	Debug                 bool
+	Record                string
+	Replay                string
+	NoColor               bool
+	SlackWebhook          string
+	TeamsWebhook          string
+	SMTPServer            string
+	SMTPUser              string
+	SMTPPassword          string
+	SMTPFrom              string
+	SMTPTo                string
+	NotifyURL             string
+	NotifySecret          string
+	IssueProject          string
+	IssueTitle            string
+	PushgatewayURL        string
+	PushgatewayJob        string
+	OTLPEndpoint          string
+	StatsdAddress         string
+	StatsdPrefix          string
+	StatsdTags            string
+	SentryDSN             string
+	AuditLog              string
+	HistoryDB             string
+	MaxIdleConns          int
+	MaxIdleConnsPerHost   int
+	MaxConnsPerHost       int
+	IdleConnTimeout       time.Duration
+	HTTP2                 bool
+	BandwidthLimit        byteSize
+	KeepAlive             bool
+	TLSMinVersion         string
+	HTTPTimeout           time.Duration
+	CACert                string
+	InsecureSkipTLSVerify bool
+	ClientCert            string
+	ClientKey             string
+	Proxy                 string
+	RegistryProxy         string
+	GitlabProxy           string
+	TokenTimeout          time.Duration
+	TagListTimeout        time.Duration
+	ManifestTimeout       time.Duration
+	DeleteTimeout         time.Duration
+	Retries               int
+	RetryBackoff          time.Duration
+	RetryMaxBackoff       time.Duration
+	RetryJitter           float64
+	RateLimitRetries      int
+	Workers               int
+	DeleteWorkers         int
+	TokenCache            string
+	FailFast              bool
+	MetadataCache         string
+	HTTPCache             string
 }
 
 // Image represents a docker image in registry
@@ -38,342 +163,1690 @@ type Image struct {
 	Tag           string
 	Digest        string
 	Created       time.Time
+	Size          int64
+	MediaType     string
+	ArtifactType  string
+	ConfigType    string
+	Blobs         []Descriptor
+	Children      []string
+	Freed         int64
 	UsedInCluster bool
+	Clusters      []string
+	Reason        string
+	Result        string
+	Repository    *Repository
 
 	sync.RWMutex
 }
 
 type kubeConfigFlags []string
 
+// wellKnownTags are never deleted unless -allow-protected is given
+var wellKnownTags = map[string]bool{
+	"latest": true,
+	"stable": true,
+	"main":   true,
+	"master": true,
+}
+
 // Cfg represents the global instance configuration
 var Cfg = &Config{}
 
 const (
-	registryTokenURL = "%s/jwt/auth?client_id=docker&offline_token=true&service=container_registry&scope=repository:%s:*"
-	imageTagsURL     = "%s/v2/%s/tags/list"
+	registryTokenURL = "%s/jwt/auth?client_id=docker&offline_token=true&service=container_registry"
+	imageTagsURL     = "%s/v2/%s/tags/list?n=1000"
 	manifestURL      = "%s/v2/%s/manifests/%s"
 )
 
 func main() {
+	defer exit()
+
+	flag.StringVar(&Cfg.ConfigFile, "config", "", "YAML file with flag names as keys. Flags given on the command line take precedence.")
 	flag.StringVar(&Cfg.GitlabURL, "giturl", "", "URL to gitlab instance")
 	flag.StringVar(&Cfg.RegistryURL, "registryurl", "", "URL to gitlab docker registry")
 	flag.StringVar(&Cfg.Username, "user", "", "Username used to access repository")
 	flag.StringVar(&Cfg.Password, "password", "", "Password used to access repository")
-	flag.StringVar(&Cfg.Repository, "repository", "", "Lookup this specific repository. Include group if repo is in a group.")
+	flag.StringVar(&Cfg.Token, "token", "", "GitLab personal access token with the api scope, used instead of -password for the registry and the GitLab API. Default is GITLAB_TOKEN")
+	flag.StringVar(&Cfg.DeployTokenUser, "deploy-token-user", "", "Username of a GitLab deploy token with the read_registry and write_registry scopes. Default is CI_DEPLOY_USER")
+	flag.StringVar(&Cfg.DeployToken, "deploy-token", "", "GitLab deploy token used for the registry instead of -user and -password or -token, which are then only used for the GitLab API. Default is CI_DEPLOY_PASSWORD")
+	flag.BoolVar(&Cfg.UseDockerConfig, "use-docker-config", false, "Take -user and -password for the registry from the Docker config file written by docker login, including credential helpers")
+	flag.StringVar(&Cfg.CredentialsSecret, "credentials-secret", "", "Take the credentials and kubeconfigs from this Kubernetes secret (namespace/name) when running inside a cluster")
+	flag.StringVar(&Cfg.VaultPath, "vault-path", "", "Take the credentials from the username, password and token fields of this Vault KV secret, e.g. secret/data/registry-pruner")
+	flag.StringVar(&Cfg.VaultAddr, "vault-addr", "", "Address of the Vault server. Default is VAULT_ADDR")
+	flag.StringVar(&Cfg.VaultToken, "vault-token", "", "Vault token. Default is VAULT_TOKEN, without one the AppRole login is used")
+	flag.StringVar(&Cfg.VaultRoleID, "vault-role-id", "", "Role ID of the Vault AppRole login, the secret ID is taken from VAULT_SECRET_ID. Default is VAULT_ROLE_ID")
+	flag.Var(&Cfg.Repositories, "repository", "Lookup this specific repository. Include group if repo is in a group. Wildcards like group/*/backend are allowed. Can be repeated.")
+	flag.BoolVar(&Cfg.Catalog, "catalog", false, "Prune all repositories listed in the registry catalog. Requires admin credentials.")
+	flag.StringVar(&Cfg.ProjectID, "project-id", "", "Numeric GitLab project id used for API requests instead of the repository path. Only allowed with a single repository.")
 	flag.Var(&Cfg.KubeConfig, "kubeconfig", "absolute path to the kubeconfig file")
+	flag.Var(&Cfg.ECSClusters, "ecs-cluster", "Look up images in the services and tasks of this Amazon ECS cluster. Can be repeated.")
+	flag.StringVar(&Cfg.ECSRegion, "ecs-region", "", "AWS region of the ECS clusters. Defaults to the AWS SDK configuration.")
+	flag.Var(&Cfg.AgentProjects, "agent-project", "Look up images in the clusters of the GitLab agents registered in this project. Can be repeated.")
 	flag.IntVar(&Cfg.MinExpiry, "minexpiry", 7, "Minimum age for images in days which shall be removed")
-	flag.StringVar(&Cfg.RegexPattern, "regexp", "", "Regex pattern which must NOT match with the image tag")
-	flag.BoolVar(&Cfg.DeleteImages, "delete", false, "If true, will delete all found images")
-	flag.Parse()
+	flag.Var(&Cfg.RegexPatterns, "regexp", "Regex pattern which must NOT match with the image tag. Alias of -keep-regexp.")
+	flag.Var(&Cfg.RegexPatterns, "keep-regexp", "Tags matching one of these regex patterns are never deleted. Takes precedence over -delete-regexp. Can be repeated or comma separated.")
+	flag.StringVar(&Cfg.TagsFrom, "tags-from", "", "Delete exactly the tags (tag or repository:tag) listed in this file, - reads stdin. Skips age, regex and cluster checks.")
+	flag.StringVar(&Cfg.FromInventory, "from-inventory", "", "Evaluate the policies against this inventory file instead of the registry. Only dry runs and plans are possible.")
+	flag.StringVar(&Cfg.ProtectFile, "protect-file", "", "File with one tag or glob pattern per line which are never deleted")
+	flag.StringVar(&Cfg.DeleteFile, "delete-file", "", "File with one tag or glob pattern per line. Only matching tags are deleted.")
+	flag.Var(&Cfg.DeleteRegexPatterns, "delete-regexp", "Only tags matching one of these regex patterns are deleted. Can be repeated or comma separated.")
+	flag.BoolVar(&Cfg.DeleteImages, "confirm", false, "Delete the found images. Without it every run is a dry run.")
+	flag.BoolVar(&Cfg.DeleteImages, "delete", false, "Deprecated alias of -confirm")
+	flag.BoolVar(&Cfg.Trash, "trash", false, "Copy images to <repository>/trash before deleting them. Use the purge-trash command to empty the trash.")
+	flag.BoolVar(&Cfg.DeleteReferrers, "delete-referrers", true, "Delete the signatures, SBOMs and attestations attached to deleted images")
+	flag.StringVar(&Cfg.GCCommand, "gc-command", "", "Shell command run after images were deleted to start the registry garbage collection, e.g. ssh registry sudo gitlab-ctl registry-garbage-collect -m")
+	flag.StringVar(&Cfg.GCWebhook, "gc-webhook", "", "URL the summary is posted to after images were deleted to start the registry garbage collection")
+	flag.Var(&Cfg.RetentionTiers, "keep-period", "Keep the newest image per period, e.g. day=14,week=13,month=12. Can be repeated.")
+	flag.IntVar(&Cfg.KeepLatest, "keep-latest", 0, "Always keep the newest number of tags per repository regardless of age")
+	flag.StringVar(&Cfg.Signatures, "signatures", "subject", "Handling of cosign signature, attestation and SBOM tags (sha256-<digest>.sig): subject (deleted with their image, orphans are deleted), keep (never deleted) or policy (pruned like other tags)")
+	flag.IntVar(&Cfg.KeepPercent, "keep-percent", 0, "Keep the newest percentage of tags. Combine with -minexpiry 0 to replace the age cutoff.")
+	flag.IntVar(&Cfg.SemverPatches, "semver-patches", 0, "Prune semantic version tags by version instead of age: keep this number of patch releases per minor version. Disabled if 0.")
+	flag.IntVar(&Cfg.SemverMajors, "semver-majors", 1, "Keep all semantic version tags of this number of latest major versions")
+	flag.Var(&Cfg.TagPolicies, "tag-policy", "Minimum age in days (or forever) for tags matching a pattern, e.g. ^main-=30. First match wins. Can be repeated.")
+	flag.IntVar(&Cfg.CacheMinExpiry, "cache-minexpiry", -1, "Minimum age in days for build cache tags, which are then pruned independently from other policies. Disabled if negative.")
+	Cfg.CacheTagPattern = regexpFlag{regexp.MustCompile(`^(cache|buildcache)|^[0-9a-f]{64}$`)}
+	flag.Var(&Cfg.CacheTagPattern, "cache-regexp", "Regex pattern identifying build cache tags. All tags of */cache repositories are cache tags.")
+	flag.IntVar(&Cfg.ChartMinExpiry, "chart-minexpiry", -1, "Minimum age in days for Helm chart artifacts, which are then pruned independently from other policies. Charts are never deleted if negative.")
+	flag.BoolVar(&Cfg.ChartReleases, "chart-releases", false, "Keep Helm charts deployed as release in one of the kubeconfig clusters")
+	flag.Var(&Cfg.ArtifactTypes, "artifact-type", "Only prune artifacts of this type: image, chart, sbom, signature, attestation or a media type. Can be repeated.")
+	flag.Var(&Cfg.DropPlatforms, "drop-platform", "Instead of pruning tags, remove this platform (e.g. linux/arm/v7) from all multi-arch tags. Can be repeated.")
+	flag.BoolVar(&Cfg.AllowProtected, "allow-protected", false, "Allow deleting the well-known tags latest, stable, main and master")
+	flag.BoolVar(&Cfg.ProtectedTags, "protected-tags", true, "Skip tags matching the project's protected container tag rules. Needs -token or a password which is a personal access token, skipped with a warning if neither is given.")
+	flag.Var(&Cfg.SignedKeys, "protect-signed-key", "Skip images with a valid cosign signature of this public key file. Can be repeated.")
+	flag.Var(&Cfg.SignedIdentities, "protect-signed-identity", "Skip images with a valid keyless cosign or notation signature whose certificate has this email, URI or subject. Can be repeated.")
+	flag.StringVar(&Cfg.SignedRoots, "protect-signed-roots", "", "PEM file with the CA certificates signing certificates of -protect-signed-identity must chain to, e.g. the Fulcio or notation trust store roots")
+	flag.BoolVar(&Cfg.RunningPipelines, "running-pipelines", true, "Skip tags of commits or refs with running or pending pipelines. Needs -token or a password which is a personal access token, skipped with a warning if neither is given.")
+	flag.StringVar(&Cfg.DependencyProxyGroup, "dependency-proxy-group", "", "Clean up the dependency proxy of this group instead of a registry repository")
+	flag.StringVar(&Cfg.PackageTypes, "packages", "", "Clean up the project's package registry for these package types (e.g. generic,maven,npm) instead of the container registry")
+	flag.StringVar(&Cfg.TombstoneFile, "tombstone-file", "", "State file recording deletion candidates. Images are only deleted once they are candidates for -grace-period days.")
+	flag.IntVar(&Cfg.GracePeriod, "grace-period", 7, "Days an image must be a recorded deletion candidate before it is deleted. Requires -tombstone-file.")
+	flag.IntVar(&Cfg.MaxDeletePercent, "max-delete-percent", 80, "Abort if more than this percentage of a repository's tags would be deleted, 0 disables the check")
+	flag.BoolVar(&Cfg.Force, "force", false, "Delete even if -max-delete-percent is exceeded")
+	flag.Var(&Cfg.TargetSize, "target-size", "Only delete the oldest candidates until the estimated storage of a repository is below this size (e.g. 200GB). Combine with -minexpiry 0 to replace the age cutoff.")
+	flag.StringVar(&Cfg.Strategy, "strategy", "age", "Order in which candidates are deleted if -max-deletions or -target-size limit the deletions: age (oldest first) or size (largest reclaimable storage first)")
+	flag.IntVar(&Cfg.MaxDeletions, "max-deletions", 0, "Abort if more images would be deleted, 0 means no limit")
+	flag.BoolVar(&Cfg.TruncateDeletions, "max-deletions-truncate", false, "Delete the first -max-deletions images of the -strategy instead of aborting")
+	flag.StringVar(&Cfg.Checkpoint, "checkpoint", "", "Record decided repositories and finished deletions in this file, removed when the run is complete")
+	flag.BoolVar(&Cfg.Resume, "resume", false, "Continue the interrupted run of the -checkpoint file instead of deciding and deleting everything again")
+	flag.BoolVar(&Cfg.Stream, "stream", false, "Delete the images repository by repository and release them before the next one, for registries too large to keep in memory")
+	flag.StringVar(&Cfg.Output, "output", "", "Write the decision for every tag in this format after the run: csv")
+	flag.StringVar(&Cfg.OutputFile, "output-file", "-", "File the -output is written to, - writes to stdout")
+	flag.StringVar(&Cfg.Report, "report", "", "Write a summary of the run in this format, e.g. for merge request comments: markdown")
+	flag.StringVar(&Cfg.ReportFile, "report-file", "-", "File the -report is written to, - writes to stdout")
+	flag.StringVar(&Cfg.ReportHTML, "report-html", "", "Write a standalone HTML report with sortable tables of all images per repository and cluster to this file")
+	flag.BoolVar(&Cfg.Quiet, "quiet", false, "Only print summaries and errors instead of a line per image")
+	flag.BoolVar(&Cfg.Debug, "debug", false, "Log every HTTP request with status, latency and the beginning of the response body to stderr")
+	flag.StringVar(&Cfg.Record, "record", "", "Save every HTTP response to this directory for -replay. The files contain registry tokens.")
+	flag.StringVar(&Cfg.Replay, "replay", "", "Answer HTTP requests with the responses saved by -record in this directory instead of contacting the registry. Only dry runs are possible.")
+	flag.BoolVar(&Cfg.Review, "review", false, "Review the images with age, size and cluster usage and select the ones to delete instead of confirming all")
+	flag.BoolVar(&Cfg.NoColor, "no-color", false, "Don't color the output. Colors are disabled automatically if stdout is not a terminal.")
+	flag.StringVar(&Cfg.SlackWebhook, "slack-webhook", "", "Post a summary of the run to this Slack incoming webhook URL")
+	flag.StringVar(&Cfg.TeamsWebhook, "teams-webhook", "", "Post a summary of the run as adaptive card to this Microsoft Teams incoming webhook URL")
+	flag.StringVar(&Cfg.SMTPServer, "smtp-server", "", "Send the report by email using this SMTP server (host:port)")
+	flag.StringVar(&Cfg.SMTPUser, "smtp-user", "", "Username for the SMTP server")
+	flag.StringVar(&Cfg.SMTPPassword, "smtp-password", "", "Password for the SMTP server")
+	flag.StringVar(&Cfg.SMTPFrom, "smtp-from", "", "Sender address of the report email")
+	flag.StringVar(&Cfg.SMTPTo, "smtp-to", "", "Comma separated recipients of the report email")
+	flag.StringVar(&Cfg.NotifyURL, "notify-url", "", "POST the outcome of the run as JSON to this URL")
+	flag.StringVar(&Cfg.NotifySecret, "notify-secret", "", "Sign the -notify-url body with HMAC-SHA256 using this secret, sent as X-Signature-256 header")
+	flag.StringVar(&Cfg.IssueProject, "issue-project", "", "After deleting, list the deleted images in an issue of this GitLab project (path or id). Requires the password to be a personal access token.")
+	flag.StringVar(&Cfg.IssueTitle, "issue-title", "Container registry cleanup", "Title of the -issue-project issue. Runs are appended to the open issue with this title.")
+	flag.StringVar(&Cfg.PushgatewayURL, "pushgateway-url", "", "Push the metrics of the run to this Prometheus Pushgateway")
+	flag.StringVar(&Cfg.PushgatewayJob, "pushgateway-job", "gitlab_registry_pruner", "Job name of the metrics pushed to the Pushgateway")
+	flag.StringVar(&Cfg.OTLPEndpoint, "otlp-endpoint", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), "Export OpenTelemetry traces of the registry, GitLab and cluster calls to this OTLP/HTTP endpoint, e.g. http://localhost:4318")
+	flag.StringVar(&Cfg.StatsdAddress, "statsd-address", "", "Send run and repository metrics to this statsd/DogStatsD address, e.g. localhost:8125")
+	flag.StringVar(&Cfg.StatsdPrefix, "statsd-prefix", "registry_pruner", "Prefix of the statsd metric names")
+	flag.StringVar(&Cfg.StatsdTags, "statsd-tags", "", "Comma separated DogStatsD tags added to every metric, e.g. env:prod,team:sre")
+	flag.StringVar(&Cfg.SentryDSN, "sentry-dsn", os.Getenv("SENTRY_DSN"), "Report errors with repository, tag and phase to this Sentry DSN")
+	flag.StringVar(&Cfg.AuditLog, "audit-log", "", "Append every decision and deletion as JSON line to this file")
+	flag.StringVar(&Cfg.HistoryDB, "history-db", "", "Store every run with all decisions in this SQLite database. Query it with the history command.")
+	flag.BoolVar(&Cfg.Yes, "yes", false, "Delete without asking for confirmation. Required if stdin is not a terminal.")
+	flag.DurationVar(&Cfg.PromptTimeout, "prompt-timeout", 5*time.Minute, "Abort if the confirmation prompt is not answered in time")
+	flag.IntVar(&Cfg.MaxIdleConns, "max-idle-conns", 100, "Maximum number of idle (keep-alive) HTTP connections")
+	flag.IntVar(&Cfg.MaxIdleConnsPerHost, "max-idle-conns-per-host", 100, "Maximum number of idle (keep-alive) HTTP connections per host")
+	flag.IntVar(&Cfg.MaxConnsPerHost, "max-conns-per-host", 0, "Maximum number of HTTP connections per host, 0 means no limit")
+	flag.DurationVar(&Cfg.IdleConnTimeout, "idle-conn-timeout", 90*time.Second, "Time an idle HTTP connection is kept open")
+	flag.BoolVar(&Cfg.HTTP2, "http2", true, "Use HTTP/2 if the server supports it")
+	flag.Var(&Cfg.BandwidthLimit, "bandwidth-limit", "Limit blob copies made before deleting to this many bytes per second (e.g. 10MB), so they don't saturate the registry's uplink")
+	flag.BoolVar(&Cfg.KeepAlive, "keep-alive", true, "Reuse HTTP connections for several requests")
+	flag.StringVar(&Cfg.TLSMinVersion, "tls-min-version", "1.2", "Minimum TLS version of HTTPS connections: 1.0, 1.1, 1.2 or 1.3")
+	flag.StringVar(&Cfg.CACert, "ca-cert", "", "PEM file with CA certificates trusted for the GitLab and registry endpoints in addition to the system ones")
+	flag.BoolVar(&Cfg.InsecureSkipTLSVerify, "insecure-skip-tls-verify", false, "Don't verify the certificates of GitLab and the registry, only for test environments")
+	flag.StringVar(&Cfg.ClientCert, "client-cert", "", "PEM file with the client certificate for GitLab and registry endpoints requiring mutual TLS")
+	flag.StringVar(&Cfg.ClientKey, "client-key", "", "PEM file with the private key of -client-cert")
+	flag.StringVar(&Cfg.Proxy, "proxy", "", "HTTP, HTTPS or SOCKS5 proxy URL for all requests, e.g. socks5://proxy:1080. Default are HTTP_PROXY, HTTPS_PROXY and NO_PROXY")
+	flag.StringVar(&Cfg.RegistryProxy, "registry-proxy", "", "Proxy URL for the requests to the registry, overrides -proxy")
+	flag.StringVar(&Cfg.GitlabProxy, "gitlab-proxy", "", "Proxy URL for the requests to GitLab, overrides -proxy")
+	flag.DurationVar(&Cfg.HTTPTimeout, "http-timeout", time.Minute, "Abort HTTP requests not answered in time, including reading the response. 0 means no timeout.")
+	flag.DurationVar(&Cfg.TokenTimeout, "token-timeout", 30*time.Second, "Timeout of registry token requests, 0 means -http-timeout")
+	flag.DurationVar(&Cfg.TagListTimeout, "tag-list-timeout", 2*time.Minute, "Timeout of tag list requests, 0 means -http-timeout")
+	flag.DurationVar(&Cfg.ManifestTimeout, "manifest-timeout", 0, "Timeout of manifest requests, 0 means -http-timeout")
+	flag.DurationVar(&Cfg.DeleteTimeout, "delete-timeout", 2*time.Minute, "Timeout of delete requests, 0 means -http-timeout")
+	flag.IntVar(&Cfg.Retries, "retries", 3, "Retry requests failing with a network error or 5xx response up to this many times")
+	flag.DurationVar(&Cfg.RetryBackoff, "retry-backoff", time.Second, "Wait before the first retry, doubled for every further retry")
+	flag.DurationVar(&Cfg.RetryMaxBackoff, "retry-max-backoff", 30*time.Second, "Maximum wait between retries")
+	flag.Float64Var(&Cfg.RetryJitter, "retry-jitter", 0.2, "Randomly vary the wait between retries by this fraction")
+	flag.IntVar(&Cfg.Workers, "workers", 4, "Number of manifests fetched in parallel")
+	flag.IntVar(&Cfg.DeleteWorkers, "delete-workers", 4, "Number of manifests deleted in parallel")
+	flag.BoolVar(&Cfg.FailFast, "fail-fast", false, "Abort the run on the first failed manifest fetch or deletion instead of continuing with the next image")
+	flag.StringVar(&Cfg.TokenCache, "token-cache", "", "Keep registry tokens in this file and use them in later runs until they expire")
+	flag.StringVar(&Cfg.HTTPCache, "http-cache", "", "Keep tag lists and manifests with their ETag in this directory and only download them again if they changed")
+	flag.StringVar(&Cfg.MetadataCache, "metadata-cache", "", "Keep created date, size and blobs of manifests in this SQLite database, later runs only fetch the manifests of new digests")
+	flag.IntVar(&Cfg.RateLimitRetries, "rate-limit-retries", 10, "Retry requests rate limited with 429 Too Many Requests up to this many times, waiting as long as Retry-After asks")
+	parseFlags(flag.CommandLine, os.Args[1:])
+	if err := run(); err != nil {
+		fail(err)
+	}
+}
+
+// run validates the configuration and runs the requested command
+func run() error {
+	// Load config file
+	if Cfg.ConfigFile != "" {
+		if err := loadConfigFile(Cfg.ConfigFile); err != nil {
+			return err
+		}
+	}
+
+	// Take the token from the environment, a flag default would be printed by -help
+	if Cfg.Token == "" {
+		Cfg.Token = os.Getenv("GITLAB_TOKEN")
+	}
+
+	// Read the credentials from the Kubernetes secret
+	if Cfg.CredentialsSecret != "" {
+		if err := useCredentialsSecret(); err != nil {
+			return err
+		}
+	}
+
+	// Fetch the credentials from Vault
+	if Cfg.VaultPath != "" {
+		if err := useVault(); err != nil {
+			return err
+		}
+	}
+
+	// Reuse the credentials of docker login, registries of the config file look up their own
+	if Cfg.UseDockerConfig && len(Cfg.Registries) == 0 {
+		if err := useDockerConfig(); err != nil {
+			return err
+		}
+	}
+
+	// Fall back to the deploy token of the GitLab CI job if no credentials are given
+	if Cfg.Username == "" && Cfg.Password == "" && Cfg.Token == "" && Cfg.DeployTokenUser == "" && Cfg.DeployToken == "" {
+		Cfg.DeployTokenUser, Cfg.DeployToken = os.Getenv("CI_DEPLOY_USER"), os.Getenv("CI_DEPLOY_PASSWORD")
+	}
+	if (Cfg.DeployTokenUser == "") != (Cfg.DeployToken == "") {
+		return errors.New("-deploy-token-user and -deploy-token must be given together")
+	}
+
+	// Prune the registry of the project when running in a GitLab CI job without credentials
+	useCIJobToken()
+
+	// Enable colors for terminals
+	initColor()
+
+	// Validate output format
+	if Cfg.Output != "" && !outputFormats[Cfg.Output] {
+		return fmt.Errorf("unknown output format %q", Cfg.Output)
+	}
+	if Cfg.Report != "" && !reportFormats[Cfg.Report] {
+		return fmt.Errorf("unknown report format %q", Cfg.Report)
+	}
+	if Cfg.Strategy != "age" && Cfg.Strategy != "size" {
+		return fmt.Errorf("unknown strategy %q", Cfg.Strategy)
+	}
+	if !signatureModes[Cfg.Signatures] {
+		return fmt.Errorf("unknown signatures mode %q", Cfg.Signatures)
+	}
+
+	// Load tag lists
+	var err error
+	if protectList, err = loadTagList(Cfg.ProtectFile); err != nil {
+		return err
+	}
+	if deleteList, err = loadTagList(Cfg.DeleteFile); err != nil {
+		return err
+	}
+
+	// Resumed runs continue the checkpoint
+	if Cfg.Resume && Cfg.Checkpoint == "" {
+		return errors.New("-resume needs the -checkpoint file of the interrupted run")
+	}
+
+	// Subcommands work on a single registry
+	if len(Cfg.Registries) > 0 && ((flag.Arg(0) != "" && flag.Arg(0) != "serve") || Cfg.DependencyProxyGroup != "" || Cfg.PackageTypes != "") {
+		return errors.New("the registries of the config file can only be pruned, use -registryurl for other commands")
+	}
+
+	// Fail before scanning if nobody can answer the confirmation prompt or review
+	if Cfg.DeleteImages && !Cfg.Yes && !isTerminal(os.Stdin) && flag.Arg(0) != "serve" {
+		return errors.New("stdin is not a terminal, use -yes to delete without confirmation")
+	}
+	if Cfg.DeleteImages && Cfg.Review && !isTerminal(os.Stdin) {
+		return errors.New("stdin is not a terminal, -review needs an interactive terminal")
+	}
+
+	// Replayed runs can't delete anything
+	if Cfg.Replay != "" && Cfg.DeleteImages {
+		return errors.New("-replay can't delete images")
+	}
+
+	// Load trusted signers
+	if len(Cfg.SignedKeys) > 0 || len(Cfg.SignedIdentities) > 0 {
+		if err := loadTrustedSigners(); err != nil {
+			return err
+		}
+	}
+
+	// Load inventory for offline runs
+	if Cfg.FromInventory != "" {
+		if Cfg.DeleteImages || len(Cfg.DropPlatforms) > 0 {
+			return errors.New("-from-inventory can't delete images, create a plan and apply it instead")
+		}
+		if trustedSigners.keys != nil || trustedSigners.roots != nil {
+			return errors.New("-from-inventory can't verify signatures")
+		}
+		if offlineInventory, err = loadInventory(Cfg.FromInventory); err != nil {
+			return err
+		}
+	}
+
+	// Create shared http client
+	if err := checkEndpoints(); err != nil {
+		return err
+	}
+	if err := initHTTPClient(); err != nil {
+		return err
+	}
+
+	// Run subcommand
+	switch flag.Arg(0) {
+	case "restore":
+		return restore(flag.Args()[1:])
+	case "purge-trash":
+		return purgeTrash(flag.Args()[1:])
+	case "plan":
+		return plan(flag.Args()[1:])
+	case "apply":
+		return apply(flag.Args()[1:])
+	case "serve":
+		return serve(flag.Args()[1:])
+	case "history":
+		return history(flag.Args()[1:])
+	case "inventory":
+		return inventory(flag.Args()[1:])
+	case "report":
+		return report(flag.Args()[1:])
+	}
+
+	// Clean up the dependency proxy instead of the registry
+	if Cfg.DependencyProxyGroup != "" {
+		return pruneDependencyProxy()
+	}
+
+	// Clean up the package registry instead of the container registry
+	if Cfg.PackageTypes != "" {
+		return prunePackages()
+	}
+
+	// --- Prune the registries ---
+	return pruneAll()
+}
+
+// prune deletes the images of all repositories according to the policies and reports the outcome
+func prune() (err error) {
+	// --- Finish running requests on SIGINT or SIGTERM ---
+	handleSignals()
 
+	// --- Trace the run if requested ---
+	span := startSpan("prune")
+	defer func() { exportTraces(span, err) }()
+
+	// --- Send the summary of the run when done ---
+	defer func() { notify(err) }()
+
+	// --- Collect the images to delete ---
+	images, err := collectImages()
+	if err != nil {
+		return err
+	}
+	if Cfg.Output != "" || Cfg.Report != "" || Cfg.ReportHTML != "" {
+		if err := setImageDigest(images); err != nil {
+			return err
+		}
+	}
+	if Cfg.Output != "" {
+		defer func() { err = firstError(err, writeOutput()) }()
+	}
+	if Cfg.Report != "" {
+		defer func() { err = firstError(err, writeReport()) }()
+	}
+	if Cfg.ReportHTML != "" {
+		defer func() { err = firstError(err, writeHTMLReport()) }()
+	}
+	if Cfg.AuditLog != "" {
+		defer func() { err = firstError(err, auditDecisions()) }()
+	}
+
+	// --- Print resulting images ---
+	for _, image := range images {
+		logf(lineDeleted, "%sImage will be deleted: %s:%s\n", dryRunPrefix(), image.Name, image.Tag)
+		image.Result = "delete"
+	}
+
+	// --- Estimate the storage freed, layers of kept tags stay ---
+	if len(images) > 0 && offlineInventory == nil {
+		if err := setImageDigest(allImages()); err != nil {
+			return err
+		}
+	}
+	freed, shared := reclaimableBytes(images)
+	fmt.Printf("%s%d images will be deleted, about %s can be reclaimed (%s of their layers are shared with kept tags)\n",
+		dryRunPrefix(), len(images), formatBytes(freed), formatBytes(shared))
+	printDryRunNotice()
+
+	// --- Give the user the chance to think about it ---
+	if isInterrupted() {
+		fmt.Println("Interrupted, nothing was deleted")
+		return nil
+	}
+	if Cfg.DeleteImages && len(images) > 0 {
+		// Set image digest
+		if err := resolveDigests(images); err != nil {
+			return err
+		}
+
+		if Cfg.Review {
+			// Let the user select the images to delete
+			if images, err = reviewCandidates(images); len(images) == 0 {
+				return err
+			}
+		} else if !Cfg.Yes {
+			if ok, err := confirmDeletion(); !ok {
+				return err
+			}
+		}
+
+		// Start delete process
+		fmt.Println("--- Starting delete process ---")
+
+		// Keep a copy in the trash repository
+		if Cfg.Trash {
+			if err := trashImages(images); err != nil {
+				return err
+			}
+		}
+
+		// Delete images
+		if err := deleteImages(images); err != nil {
+			return err
+		}
+	}
+
+	// --- The run is complete, a resumed run would start from scratch ---
+	if !isInterrupted() {
+		return removeCheckpoint()
+	}
+	return nil
+}
+
+// prepareRepositories discovers and expands the repositories to process
+func prepareRepositories() error {
 	// Parse registry url (remove protocol)
 	Cfg.RegistryURLShort = strings.Replace(Cfg.RegistryURL, "https://", "", 1)
 	Cfg.RegistryURLShort = strings.Replace(Cfg.RegistryURLShort, "http://", "", 1)
 
+	// --- Take the repositories from the inventory when offline ---
+	var err error
+	if offlineInventory != nil {
+		Cfg.Repositories, err = offlineInventory.repositories(Cfg.Repositories)
+	} else {
+		// --- Discover repositories from the registry catalog ---
+		if Cfg.Catalog {
+			catalog, err := getCatalog()
+			if err != nil {
+				return err
+			}
+			Cfg.Repositories = append(Cfg.Repositories, catalog...)
+		}
+
+		// --- Expand wildcard repository patterns ---
+		Cfg.Repositories, err = expandRepositories(Cfg.Repositories)
+	}
+	if err != nil {
+		return err
+	}
+
+	// --- The project id belongs to one repository, others would get its protected tags and pipelines ---
+	if Cfg.ProjectID != "" && len(Cfg.Repositories) > 1 {
+		return fmt.Errorf("-project-id can only be used with a single repository, found %d", len(Cfg.Repositories))
+	}
+	return nil
+}
+
+// collectImages returns the images to delete of all repositories after applying all policies and safeguards
+func collectImages() ([]*Image, error) {
+	// --- Find the repositories ---
+	if err := prepareRepositories(); err != nil {
+		return nil, err
+	}
+
+	// --- Collect the images to delete of all repositories ---
+	var images []*Image
+	var err error
+	if Cfg.TagsFrom != "" {
+		// Tags to delete are given, skip the analysis
+		if images, err = getListedImages(Cfg.TagsFrom); err != nil {
+			return nil, err
+		}
+	} else {
+		for _, path := range Cfg.Repositories {
+			if isInterrupted() {
+				fmt.Println("Interrupted, the remaining repositories are not scanned")
+				break
+			}
+
+			// Repositories of the interrupted run are not decided again
+			candidates, ok, err := checkpointCandidates(path)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				images = append(images, candidates...)
+				continue
+			}
+			// A failed repository is skipped, the others are still pruned
+			var repo *Repository
+			ok, err = tolerate("scan repository", path, "", func() error {
+				var err error
+				repo = newRepository(path)
+				candidates, err = getCandidates(repo)
+				return err
+			})
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+			if err := checkpointDecided(repo, candidates); err != nil {
+				return nil, err
+			}
+			images = append(images, candidates...)
+		}
+
+		// --- Look up images in clusters ---
+		if images, err = removeUsedImages(images); err != nil {
+			return nil, err
+		}
+	}
+	return limitDeletions(images, Cfg.MaxDeletions)
+}
+
+// limitDeletions applies the grace period, the target size and the deletion limits to the images to delete
+func limitDeletions(images []*Image, maxDeletions int) ([]*Image, error) {
+	// --- Only delete images which were already candidates before the grace period ---
+	if Cfg.TombstoneFile != "" {
+		var err error
+		if images, err = filterTombstones(images, Cfg.TombstoneFile, Cfg.GracePeriod); err != nil {
+			return nil, err
+		}
+	}
+
+	// --- Only delete until the repositories are below the target size ---
+	if Cfg.TargetSize > 0 {
+		var err error
+		if images, err = filterTargetSize(images, int64(Cfg.TargetSize)); err != nil {
+			return nil, err
+		}
+	}
+
+	// --- Refuse to delete too large parts of a repository ---
+	if Cfg.MaxDeletePercent > 0 && !Cfg.Force {
+		exceeded := false
+		count := make(map[*Repository]int)
+		for _, image := range images {
+			count[image.Repository]++
+		}
+		for repo, c := range count {
+			if percent := c * 100 / repo.TagCount; percent > Cfg.MaxDeletePercent {
+				fmt.Printf("Repository %s would lose %d%% of its tags (%d of %d), maximum is %d%%\n",
+					repo.Name, percent, c, repo.TagCount, Cfg.MaxDeletePercent)
+				exceeded = true
+			}
+		}
+		if exceeded {
+			return nil, errors.New("too many tags would be deleted, use -force to delete anyway")
+		}
+	}
+
+	// --- Enforce the maximum number of deletions ---
+	if maxDeletions > 0 && len(images) > maxDeletions {
+		if !Cfg.TruncateDeletions {
+			return nil, fmt.Errorf("%d images exceed the maximum of %d deletions", len(images), maxDeletions)
+		}
+
+		// Only delete the first images of the strategy
+		if err := sortCandidates(images); err != nil {
+			return nil, err
+		}
+		first := map[string]string{"age": "oldest", "size": "largest"}[Cfg.Strategy]
+		fmt.Printf("%d images exceed the maximum of %d deletions, only the %s are deleted\n", len(images), maxDeletions, first)
+		for _, image := range images[maxDeletions:] {
+			image.Reason = "exceeds maximum deletions"
+		}
+		images = images[:maxDeletions]
+	}
+	return images, nil
+}
+
+// getCandidates returns the images of the repository which shall be deleted unless they are used in a cluster
+func getCandidates(repo *Repository) ([]*Image, error) {
+	defer startSpan("repository", "repository", repo.Name).finish()
+
+	// --- Get all image tags from the inventory when offline ---
+	if offlineInventory != nil {
+		return filterCandidates(repo, offlineInventory.images(repo))
+	}
+
 	// --- Get gitlab registry token ---
-	token := getRegistryToken()
+	var err error
+	if repo.Token, err = getRegistryToken(repo); err != nil {
+		return nil, err
+	}
 
 	// --- Get all image tags from the repository ---
-	images := getImages(token)
+	images, err := getImages(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	// --- Drop platforms from multi-arch images instead of pruning tags ---
+	if len(Cfg.DropPlatforms) > 0 {
+		return nil, dropPlatforms(repo, images)
+	}
 
 	// --- Set the time when the image was created ---
-	setImageUploadDate(token, images)
+	if err := setImageUploadDate(repo, images); err != nil {
+		return nil, err
+	}
+	candidates, err := filterCandidates(repo, images)
+	return withoutFailed(candidates), err
+}
 
-	// --- Remove images from the slice which are too young ---
-	// Calculate min expiry date
-	minExpiryDate := time.Now().AddDate(0, 0, Cfg.MinExpiry*-1)
+// filterCandidates applies the policies of the repository to its images and returns the ones to delete
+func filterCandidates(repo *Repository, images []*Image) ([]*Image, error) {
+	// --- Remove artifacts which don't have one of the requested types ---
+	if len(Cfg.ArtifactTypes) > 0 {
+		var err error
+		if images, err = filterArtifactTypes(images, Cfg.ArtifactTypes); err != nil {
+			return nil, err
+		}
+	}
+
+	// --- Separate signature tags which follow their image instead of the tag policies ---
+	var orphanedSignatures []*Image
+	var err error
+	if repo.signatures() != "policy" {
+		if images, orphanedSignatures, err = splitSignatures(repo, images); err != nil {
+			return nil, err
+		}
+	}
 
-	// Remove images
+	// --- Separate build cache images which have their own policy ---
+	var cacheImages []*Image
+	if Cfg.CacheMinExpiry >= 0 {
+		images, cacheImages = splitBuildCache(images)
+	}
+
+	// --- Separate helm charts which have their own policy ---
+	images, charts := splitHelmCharts(images)
+
+	// --- Separate semantic version tags which have their own policy ---
+	var versioned []*Image
+	if Cfg.SemverPatches > 0 {
+		images, versioned = splitSemver(images)
+	}
+
+	// Remember all images for the keep latest policy
+	all := make([]*Image, len(images))
+	copy(all, images)
+
+	// --- Keep the newest percentage of images if provided ---
+	if Cfg.KeepPercent > 0 {
+		images = filterKeepPercent(images, Cfg.KeepPercent)
+	}
+
+	// --- Keep the newest image per period if retention tiers are provided ---
+	if len(Cfg.RetentionTiers) > 0 {
+		images = filterRetentionTiers(images, Cfg.RetentionTiers)
+	}
+
+	// --- Remove images from the slice which are too young ---
+	for _, policy := range Cfg.TagPolicies {
+		policy.Matched, policy.Expired = 0, 0
+	}
 	i := 0
 	for _, image := range images {
-		if image.Created.Before(minExpiryDate) {
+		// Use the tag policy expiry if one matches
+		minExpiry := repo.minExpiry()
+		policy := Cfg.TagPolicies.match(image.Tag)
+		if policy != nil {
+			policy.Matched++
+			minExpiry = policy.Days
+		}
+
+		// Calculate min expiry date
+		if minExpiry >= 0 && image.Created.Before(time.Now().AddDate(0, 0, minExpiry*-1)) {
 			// This image should stay in slice
 			images[i] = image
 			i++
+			if policy != nil {
+				policy.Expired++
+			}
+		} else if minExpiry < 0 && policy != nil {
+			// Print information
+			logf(lineKept, "Image %s:%s matches tag policy %s, kept forever\n", image.Name, image.Tag, policy.Pattern.String())
+			image.Reason = "tag policy " + policy.Pattern.String()
+		} else if minExpiry < 0 {
+			// Print information
+			logf(lineKept, "Image %s:%s is kept forever by the min expiry of %s\n", image.Name, image.Tag, repo.Name)
+			image.Reason = "min expiry"
 		} else {
 			// Print information
-			fmt.Printf("Image %s:%s is too young, skipped: %s\n", image.Name, image.Tag, image.Created.String())
+			logf(lineKept, "Image %s:%s is too young, skipped: %s\n", image.Name, image.Tag, image.Created.String())
+			image.Reason = "too young"
 		}
 	}
 	// Remove the rest
 	images = images[:i]
 
-	// --- Remove images which does not match the regex pattern if provided ---
-	if Cfg.RegexPattern != "" {
+	// Print tag policy report
+	for _, policy := range Cfg.TagPolicies {
+		fmt.Printf("Tag policy %s (%s) in %s: %d matched, %d expired\n",
+			policy.Pattern.String(), policy.expiry(), repo.Name, policy.Matched, policy.Expired)
+	}
+
+	// --- Keep the newest images if requested ---
+	if count := repo.keepLatest(); count > 0 {
+		images = filterKeepLatest(images, all, count)
+	}
+
+	// --- Add expired build cache images ---
+	images = append(images, expireBuildCache(cacheImages)...)
+
+	// --- Add expired semantic version tags ---
+	images = append(images, expireSemver(versioned)...)
+
+	// --- Add expired helm charts ---
+	expiredCharts, err := expireHelmCharts(charts)
+	if err != nil {
+		return nil, err
+	}
+	images = append(images, expiredCharts...)
+
+	// --- Add signatures whose image is gone ---
+	images = append(images, orphanedSignatures...)
+
+	// --- Remove images matching the keep regex or protect list and those not matching the delete regex or list ---
+	// Keep takes precedence over delete.
+	keepRegexps, deleteRegexps := repo.keepRegexps(), repo.deleteRegexps()
+	if len(keepRegexps) > 0 || len(deleteRegexps) > 0 || protectList != nil || deleteList != nil {
+		i = 0
+		for _, image := range images {
+			if pattern, matched := keepRegexps.match(image.Tag); matched {
+				// Print information
+				logf(lineKept, "Image %s:%s matches keep regexp, skipped: %s\n", image.Name, image.Tag, pattern)
+				image.Reason = "matches keep regexp " + pattern
+			} else if pattern, matched := protectList.match(image.Tag); matched {
+				// Print information
+				logf(lineKept, "Image %s:%s is in the protect file, skipped: %s\n", image.Name, image.Tag, pattern)
+				image.Reason = "in protect file"
+			} else if !isDeleteListed(image.Tag, deleteRegexps) {
+				// Print information
+				logf(lineKept, "Image %s:%s does not match any delete regexp or delete file entry, skipped\n", image.Name, image.Tag)
+				image.Reason = "not matching delete regexp or file"
+			} else {
+				// This image should stay in slice
+				images[i] = image
+				i++
+			}
+		}
+		// Remove the rest
+		images = images[:i]
+	}
+
+	// --- Remove well-known tags every deployment depends on ---
+	images = filterWellKnownTags(images)
+
+	// --- Remove images which are protected by GitLab protected tag rules ---
+	if Cfg.ProtectedTags {
+		patterns, err := getProtectedTagPatterns(repo)
+		if err != nil {
+			return nil, err
+		}
 		i = 0
 		for _, image := range images {
-			if matched, _ := regexp.MatchString(Cfg.RegexPattern, image.Tag); !matched {
+			protected := false
+			for _, pattern := range patterns {
+				if pattern.MatchString(image.Tag) {
+					protected = true
+					break
+				}
+			}
+
+			if !protected {
 				// This image should stay in slice
 				images[i] = image
 				i++
 			} else {
 				// Print information
-				fmt.Printf("Image %s:%s matches regexp, skipped: %s\n", image.Name, image.Tag, Cfg.RegexPattern)
+				logf(lineProtected, "Image %s:%s is protected by GitLab, skipped\n", image.Name, image.Tag)
+				image.Reason = "protected by GitLab"
 			}
 		}
 		// Remove the rest
 		images = images[:i]
 	}
 
-	// --- Look up images in kubernetes clusters ---
-	// Create wait group
-	var wg sync.WaitGroup
-	wg.Add(len(Cfg.KubeConfig)) // Per cluster one goroutine
+	// --- Remove images which carry a trusted signature ---
+	if len(Cfg.SignedKeys) > 0 || len(Cfg.SignedIdentities) > 0 {
+		if images, err = filterSignedImages(repo, images); err != nil {
+			return nil, err
+		}
+	}
+
+	// --- Remove images which are used by running pipelines ---
+	if Cfg.RunningPipelines {
+		hints, err := getPipelineTagHints(repo)
+		if err != nil {
+			return nil, err
+		}
+		i = 0
+		for _, image := range images {
+			if !usedByPipeline(image.Tag, hints) {
+				// This image should stay in slice
+				images[i] = image
+				i++
+			} else {
+				// Print information
+				logf(lineKept, "Image %s:%s belongs to a running pipeline, skipped\n", image.Name, image.Tag)
+				image.Reason = "running pipeline"
+			}
+		}
+		// Remove the rest
+		images = images[:i]
+	}
+	return images, nil
+}
 
-	// Create goroutine per cluster
-	for _, config := range Cfg.KubeConfig {
-		go setImagesClusterUsage(images, config, &wg)
+// removeUsedImages looks up the images in all clusters and removes the used ones from the slice
+func removeUsedImages(images []*Image) ([]*Image, error) {
+	defer timePhase("scan clusters")()
+	if offlineInventory == nil {
+		if err := scanClusters(images); err != nil {
+			return nil, err
+		}
 	}
-	wg.Wait()
+	return filterUsedImages(images), nil
+}
 
-	// --- Print resulting images ---
+// filterUsedImages removes the images used in a cluster from the slice
+func filterUsedImages(images []*Image) []*Image {
+	i := 0
 	for _, image := range images {
 		if !image.UsedInCluster {
-			fmt.Printf("Image will be deleted: %s:%s\n", image.Name, image.Tag)
+			// This image should stay in slice
+			images[i] = image
+			i++
+		} else {
+			image.Reason = "used in cluster"
 		}
 	}
+	return images[:i]
+}
 
-	// --- Give the user the chance to think about it ---
-	if Cfg.DeleteImages {
-		reader := bufio.NewReader(os.Stdin)
-		fmt.Println("Do you really want to delete the images listed above? Please type yes if so...")
-		fmt.Printf("> ")
-		text, _ := reader.ReadString('\n')
-		if text != "yes\n" {
+// scanClusters looks up the images in all Kubernetes and ECS clusters
+func scanClusters(images []*Image) error {
+	// Pods can reference images by digest or the digest of a platform
+	clusters, err := getClusters()
+	if err != nil {
+		return err
+	}
+	if len(clusters)+len(Cfg.ECSClusters) > 0 {
+		if err := resolveDigests(images); err != nil {
+			return err
+		}
+	}
+	refs, err := collectImageReferences()
+	if err != nil {
+		return err
+	}
+	markUsedImages(images, refs)
+	return nil
+}
+
+// imageReference is an image used in a cluster
+type imageReference struct {
+	Cluster string
+	Ref     string
+	// Where the image is used, e.g. cluster, namespace and pod
+	Location string
+}
+
+// collectImageReferences returns the images used in all Kubernetes and ECS clusters
+func collectImageReferences() ([]imageReference, error) {
+	var refs []imageReference
+	var mutex sync.Mutex
+	add := func(clusterRefs []imageReference) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		refs = append(refs, clusterRefs...)
+	}
+
+	// Scan all clusters at once, errors are passed on to the caller
+	clusters, err := getClusters()
+	if err != nil {
+		return nil, err
+	}
+	n := len(clusters) + len(Cfg.ECSClusters)
+	bar := newProgress("Scanning clusters", n)
+	err = forEach(n, n, func(i int) error {
+		defer bar.add(1)
+		if i < len(clusters) {
+			clusterRefs, err := getClusterReferences(clusters[i])
+			if err != nil {
+				return fmt.Errorf("cannot scan cluster %s: %w", clusters[i].Name, err)
+			}
+			add(clusterRefs)
+		} else {
+			clusterRefs, err := getECSReferences(Cfg.ECSClusters[i-len(clusters)])
+			if err != nil {
+				return fmt.Errorf("cannot scan ECS cluster %s: %w", Cfg.ECSClusters[i-len(clusters)], err)
+			}
+			add(clusterRefs)
+		}
+		return nil
+	})
+	bar.finish()
+	return refs, err
+}
+
+// markUsedImages marks the images used by one of the references with their clusters
+func markUsedImages(images []*Image, refs []imageReference) {
+	for _, image := range images {
+		for _, ref := range refs {
+			if image.isReferencedBy(ref.Ref) && !image.usedIn(ref.Cluster) {
+				image.setUsedIn(ref.Cluster)
+
+				// Print output
+				logf(lineKept, "Image %s:%s is used in %s\n", image.Name, image.Tag, ref.Location)
+			}
+		}
+	}
+}
+
+// isReferencedBy returns true if the image reference (registry/name:tag or registry/name@digest,
+// optionally prefixed by docker-pullable://) points to the image or one of its platforms
+func (image *Image) isReferencedBy(ref string) bool {
+	name := fmt.Sprintf("%s/%s", Cfg.RegistryURLShort, image.Name)
+	ref = strings.TrimPrefix(ref, "docker-pullable://")
+	if ref == name+":"+image.Tag {
+		return true
+	}
+
+	// Compare digest references
+	i := strings.LastIndex(ref, "@")
+	if i < 0 || image.Digest == "" {
+		return false
+	}
+	refName, digest := ref[:i], ref[i+1:]
+	if j := strings.LastIndex(refName, ":"); j > strings.LastIndex(refName, "/") {
+		// Drop the tag of name:tag@digest
+		refName = refName[:j]
+	}
+	if refName != name {
+		return false
+	}
+	if digest == image.Digest {
+		return true
+	}
+	for _, child := range image.Children {
+		if digest == child {
+			return true
+		}
+	}
+	return false
+}
+
+// setUsedIn marks the image as used in the cluster
+func (image *Image) setUsedIn(cluster string) {
+	image.Lock()
+	defer image.Unlock()
+	image.UsedInCluster = true
+	for _, c := range image.Clusters {
+		if c == cluster {
 			return
 		}
+	}
+	image.Clusters = append(image.Clusters, cluster)
+}
 
-		// Start delete process
-		fmt.Println("--- Starting delete process ---")
+// usedIn returns true if the image is used in the cluster
+func (image *Image) usedIn(cluster string) bool {
+	image.RLock()
+	defer image.RUnlock()
+	for _, c := range image.Clusters {
+		if c == cluster {
+			return true
+		}
+	}
+	return false
+}
 
-		// Set image digest
-		setImageDigest(token, images)
+// filterWellKnownTags removes the well-known tags from the slice unless -allow-protected is given
+func filterWellKnownTags(images []*Image) []*Image {
+	if Cfg.AllowProtected {
+		return images
+	}
 
-		// Delete images
-		deleteImages(images, token)
+	i := 0
+	for _, image := range images {
+		if !wellKnownTags[image.Tag] {
+			// This image should stay in slice
+			images[i] = image
+			i++
+		} else {
+			// Print information
+			logf(lineProtected, "Image %s:%s is a well-known tag, skipped\n", image.Name, image.Tag)
+			image.Reason = "well-known tag"
+		}
 	}
+	return images[:i]
+}
+
+// Exit codes
+const (
+	exitNothingDeleted = 0
+	exitError          = 1
+	exitDeleted        = 2
+	exitPartialFailure = 3
+	exitInterrupted    = 4
+)
 
+// Number of deleted and failed deletions of this run, used for the exit code
+var deletedCount, failedCount int
+
+// exit terminates the program with an exit code describing the outcome of the run.
+// A panic is a bug, it is reported like an error as a last resort.
+func exit() {
+	if r := recover(); r != nil {
+		capturePanic()
+		fail(panicError(r))
+	}
+	printFailures()
+	switch {
+	case isInterrupted():
+		os.Exit(exitInterrupted)
+	case failedCount > 0:
+		os.Exit(exitPartialFailure)
+	case deletedCount > 0:
+		os.Exit(exitDeleted)
+	}
+	os.Exit(exitNothingDeleted)
 }
 
-func setImagesClusterUsage(images []*Image, c string, wg *sync.WaitGroup) {
-	defer wg.Done()
-	config, err := clientcmd.BuildConfigFromFlags("", c)
-	if err != nil {
-		panic(err)
+// fail prints the error which aborted the run, reports it and exits with exitError
+func fail(err error) {
+	if err == errUsage {
+		// The command printed its usage already
+		os.Exit(exitError)
+	}
+	printFailures()
+	ctx := contextOf(err)
+	fmt.Fprintln(os.Stderr, colorize(colorBoldRed, "Error: "+errorMessage(err)))
+	if Cfg.Debug && ctx.Stack != nil {
+		os.Stderr.Write(ctx.Stack)
+	}
+	reportError(err, ctx)
+	os.Exit(exitError)
+}
+
+// parseFlags parses the arguments and exits with exitError if they are invalid
+func parseFlags(fs *flag.FlagSet, args []string) {
+	fs.Init(fs.Name(), flag.ContinueOnError)
+	switch err := fs.Parse(args); err {
+	case nil:
+	case flag.ErrHelp:
+		os.Exit(exitNothingDeleted)
+	default:
+		os.Exit(exitError)
+	}
+}
+
+// logf prints per-image progress in the color of its kind unless -quiet is given
+func logf(kind lineKind, format string, a ...interface{}) {
+	if !Cfg.Quiet {
+		if activeProgress != nil {
+			activeProgress.clear()
+		}
+		line := strings.TrimSuffix(fmt.Sprintf(format, a...), "\n")
+		fmt.Println(colorize(lineColor(kind), line))
+	}
+}
+
+// dryRunPrefix returns the label for planned deletions which are not executed
+func dryRunPrefix() string {
+	if Cfg.DeleteImages {
+		return ""
+	}
+	return "[dry-run] "
+}
+
+// printDryRunNotice tells the user how to actually delete if this is a dry run
+func printDryRunNotice() {
+	if !Cfg.DeleteImages {
+		fmt.Println("Dry run, nothing was deleted. Use -confirm to delete.")
+	}
+}
+
+func confirmDeletion() (bool, error) {
+	// Fail fast if nobody can answer the prompt
+	if !isTerminal(os.Stdin) {
+		return false, errors.New("stdin is not a terminal, use -yes to delete without confirmation")
+	}
+
+	// Read answer in background so the prompt can time out
+	answer := make(chan string, 1)
+	go func() {
+		reader := bufio.NewReader(os.Stdin)
+		text, _ := reader.ReadString('\n')
+		answer <- text
+	}()
+
+	fmt.Println("Do you really want to delete the images listed above? Please type yes if so...")
+	fmt.Printf("> ")
+	select {
+	case text := <-answer:
+		return text == "yes\n", nil
+	case <-time.After(Cfg.PromptTimeout):
+		fmt.Println("\nNo answer received, aborting")
+		return false, nil
+	case <-interrupted:
+		return false, nil
 	}
+}
 
-	clientset, err := kubernetes.NewForConfig(config)
+// getClusterReferences returns the images of the containers of all pods in the cluster
+func getClusterReferences(cluster Cluster) ([]imageReference, error) {
+	defer startDetachedSpan("kubernetes scan", "cluster", cluster.Name).finish()
+	clientset, err := kubernetes.NewForConfig(cluster.Config)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	// get namespaces
 	ns := clientset.CoreV1Client.Namespaces()
 	nsList, err := ns.List(v1.ListOptions{})
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	// iterate over all namespaces
+	var refs []imageReference
 	for _, nsObj := range nsList.Items {
 		// Get all pods
 		podsInterface := clientset.CoreV1Client.Pods(nsObj.Name)
 		pods, err := podsInterface.List(v1.ListOptions{})
 		if err != nil {
-			panic(err)
-		}
-
-		// Iterate all image tags
-		for id, image := range images {
-			// Format full image name
-			imageName := fmt.Sprintf("%s/%s:%s", Cfg.RegistryURLShort, image.Name, image.Tag)
-
-			// Iterate all pods
-			for _, pod := range pods.Items {
-				// Iterate containers
-				for _, cont := range pod.Spec.Containers {
-					// Image the same currently in use by container?
-					if imageName == cont.Image {
-						images[id].Lock()
-						images[id].UsedInCluster = true
-						images[id].Unlock()
-
-						// Print output
-						fmt.Printf("Image %s:%s is used in Namespace %s and pod %s\n",
-							image.Name, image.Tag, nsObj.Name, pod.Name)
-					}
+			return nil, err
+		}
+
+		// Collect the images of the containers, the status has the digest of the pulled platform
+		for _, pod := range pods.Items {
+			location := fmt.Sprintf("cluster %s, Namespace %s and pod %s", cluster.Name, nsObj.Name, pod.Name)
+			for _, cont := range pod.Spec.Containers {
+				refs = append(refs, imageReference{Cluster: cluster.Name, Ref: cont.Image, Location: location})
+			}
+			for _, status := range pod.Status.ContainerStatuses {
+				refs = append(refs, imageReference{Cluster: cluster.Name, Ref: status.ImageID, Location: location})
+			}
+		}
+	}
+	return refs, nil
+}
+
+func deleteImages(images []*Image) error {
+	defer timePhase("delete")()
+	var deleted []*Image
+	deleting := make(map[*Image]bool)
+	for _, image := range images {
+		deleting[image] = true
+	}
+	deletedChildren := make(map[string]bool)
+	if err := resolveDeletionDigests(images); err != nil {
+		return err
+	}
+
+	// Deleting a manifest deletes every tag pointing to it
+	images = keepSharedDigests(images, deleting)
+
+	// Manifests are deleted concurrently, the results are recorded under the lock.
+	// Platforms and referrers of deleted images are deleted under the lock as well
+	// since they depend on what the other deletions did.
+	var mutex sync.Mutex
+	var failed, protected, skipped []*Image
+	bar := newProgress("Deleting", len(images))
+	err := forEach(Cfg.DeleteWorkers, len(images), func(i int) error {
+		image := images[i]
+		defer bar.add(1)
+
+		// A failed deletion only fails this image
+		ok, err := tolerate("delete", image.Name, image.Tag, func() error {
+			mutex.Lock()
+			done := image.Result == "deleted"
+			mutex.Unlock()
+			if done {
+				// Already deleted as referrer of another image
+				return nil
+			}
+			if isInterrupted() {
+				mutex.Lock()
+				defer mutex.Unlock()
+				skipped = append(skipped, image)
+				return nil
+			}
+			result, ok, err := checkpointResult(image)
+			if err != nil {
+				return err
+			}
+			if ok {
+				// Deleted by the interrupted run
+				mutex.Lock()
+				defer mutex.Unlock()
+				image.Result = result
+				if result == "deleted" {
+					deleted = append(deleted, image)
 				}
+				return nil
+			}
 
-				// Extra check to leave long loop
-				if image.UsedInCluster {
-					break
+			// Find attached artifacts while the image still exists
+			var referrers []Descriptor
+			if Cfg.DeleteReferrers {
+				if referrers, err = getReferrers(image.Repository, image.Digest, nil); err != nil {
+					return err
 				}
 			}
+
+			// Create request
+			manifestURLParsed := fmt.Sprintf(manifestURL, Cfg.RegistryURL, registryPath(image.Name), image.Digest)
+			body, resp, err := doHTTPRequest(manifestURLParsed, image.Repository.Token, "DELETE", true)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			if image.Result == "deleted" {
+				// Deleted as referrer of another image in the meantime
+				return nil
+			}
+			var status int
+			switch {
+			case err != nil:
+				if Cfg.FailFast {
+					return fmt.Errorf("cannot delete %s:%s: %w", image.Name, image.Tag, err)
+				}
+				fmt.Printf("Image %s:%s not deleted: %s\n", image.Name, image.Tag, err.Error())
+				image.Result = "failed"
+				image.Reason = err.Error()
+				failed = append(failed, image)
+				recordFailure("delete", newErrorContext(image.Name, image.Tag), err)
+			case resp.StatusCode == http.StatusForbidden:
+				fmt.Printf("Image %s:%s is protected, skipped\n", image.Name, image.Tag)
+				image.Result = "protected"
+				image.Reason = "protected by GitLab"
+				protected = append(protected, image)
+			case resp.StatusCode == http.StatusNotFound:
+				logf(lineKept, "Image %s:%s not found, skipped\n", image.Name, image.Tag)
+				image.Result = "not found"
+			case resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted:
+				if Cfg.FailFast {
+					return fmt.Errorf("cannot delete %s:%s: %w", image.Name, image.Tag, newResponseError(resp, body))
+				}
+				fmt.Printf("Image %s:%s not deleted, return code %d: %s\n", image.Name, image.Tag, resp.StatusCode, body)
+				image.Result = "failed"
+				image.Reason = fmt.Sprintf("return code %d", resp.StatusCode)
+				failed = append(failed, image)
+				recordFailure("delete", newErrorContext(image.Name, image.Tag), newResponseError(resp, body))
+			default:
+				logf(lineDeleted, "Image deleted: %s:%s\n", image.Name, image.Tag)
+				image.Result = "deleted"
+				deleted = append(deleted, image)
+				if err := deleteChildren(image, deleting, deletedChildren); err != nil {
+					return err
+				}
+				if err := deleteReferrers(image, referrers); err != nil {
+					return err
+				}
+			}
+			if resp != nil {
+				status = resp.StatusCode
+			}
+			err = audit(AuditEntry{
+				Event:      "delete",
+				Repository: image.Name,
+				Tag:        image.Tag,
+				Digest:     image.Digest,
+				Decision:   image.Result,
+				Reason:     image.Reason,
+				Status:     status,
+			})
+			if err == nil && (image.Result == "deleted" || image.Result == "not found") {
+				err = checkpointDeleted(image)
+			}
+			return err
+		})
+		if !ok {
+			mutex.Lock()
+			defer mutex.Unlock()
+			if image.Result != "deleted" {
+				// Deleted images stay deleted when their platforms or referrers fail
+				image.Result = "failed"
+				image.Reason = "error"
+				failed = append(failed, image)
+			}
+		}
+		return err
+	})
+	bar.finish()
+	deletedCount += len(deleted)
+	if err != nil {
+		return err
+	}
+	freed, _ := reclaimableBytes(deleted)
+	fmt.Printf("Deleted %d images, about %s can be reclaimed\n", len(deleted), formatBytes(freed))
+	if len(failed) > 0 {
+		fmt.Printf("Failed to delete %d images, they are listed at the end\n", len(failed))
+	}
+	if len(protected) > 0 {
+		fmt.Printf("%d images are protected and were not deleted:\n", len(protected))
+		for _, image := range protected {
+			fmt.Printf("  %s:%s\n", image.Name, image.Tag)
 		}
 	}
+	if len(skipped) > 0 {
+		fmt.Printf("Interrupted, %d images were not deleted:\n", len(skipped))
+		for _, image := range skipped {
+			fmt.Printf("  %s:%s\n", image.Name, image.Tag)
+		}
+	}
+
+	// Free the disk space, streamed runs do it once at the end
+	if len(deleted) > 0 && !Cfg.Stream && !isInterrupted() {
+		collectGarbage()
+	}
+	return nil
 }
 
-func deleteImages(images []*Image, token string) {
+// resolveDeletionDigests resolves the digests of all tags of the repositories of the images before
+// deleting, the concurrent deletions then only read them. Kept tags and the platforms of kept manifest
+// lists can share the manifest of a deleted image, referrers are found by tags of the tag schema.
+func resolveDeletionDigests(images []*Image) error {
+	var lookup []*Image
+	seen := make(map[*Repository]bool)
 	for _, image := range images {
-		// Create request
-		manifestURLParsed := fmt.Sprintf(manifestURL, Cfg.RegistryURL, Cfg.Repository, image.Digest)
-		sendHTTPRequest(manifestURLParsed, token, "DELETE", true)
-		fmt.Printf("Image deleted: %s:%s\n", image.Name, image.Tag)
+		if !seen[image.Repository] {
+			seen[image.Repository] = true
+			lookup = append(lookup, image.Repository.Images...)
+		}
 	}
+	return resolveDigests(lookup)
 }
 
-func setImageDigest(token string, images []*Image) {
-	for id, image := range images {
-		// Create request
-		manifestURLParsed := fmt.Sprintf(manifestURL, Cfg.RegistryURL, Cfg.Repository, image.Tag)
-		body, resp := sendHTTPRequest(manifestURLParsed, token, "GET", true)
-
-		// Extract image tags from response
-		var data map[string]interface{}
-		if err := json.Unmarshal(body, &data); err != nil {
-			panic(err)
+// keepSharedDigests removes the images whose manifest a kept tag of their repository references,
+// directly or as platform of a manifest list, from the slice and from deleting. The registry deletes
+// manifests by digest, which deletes every tag pointing to it.
+func keepSharedDigests(images []*Image, deleting map[*Image]bool) []*Image {
+	referenced := make(map[*Repository]map[string]bool)
+	i := 0
+	for _, image := range images {
+		if _, ok := referenced[image.Repository]; !ok {
+			referenced[image.Repository] = keptDigests(image.Repository, deleting)
 		}
+		if !referenced[image.Repository][image.Digest] {
+			// This image should stay in slice
+			images[i] = image
+			i++
+		} else {
+			// Print information
+			logf(lineKept, "Image %s:%s shares its digest with a kept tag, skipped\n", image.Name, image.Tag)
+			image.Result = ""
+			image.Reason = "digest shared with kept tag"
+			delete(deleting, image)
+		}
+	}
+	return images[:i]
+}
 
-		// Get digest
-		images[id].Digest = resp.Header.Get("Docker-Content-Digest")
+// keptDigests returns the manifests the tags of the repository which are not deleted reference
+func keptDigests(repo *Repository, deleting map[*Image]bool) map[string]bool {
+	referenced := make(map[string]bool)
+	for _, image := range repo.Images {
+		if deleting[image] || image.Digest == "" {
+			continue
+		}
+		referenced[image.Digest] = true
+		for _, child := range image.Children {
+			referenced[child] = true
+		}
 	}
+	return referenced
 }
 
-func setImageUploadDate(token string, images []*Image) {
-	for id, image := range images {
-		// Create request
-		manifestURLParsed := fmt.Sprintf(manifestURL, Cfg.RegistryURL, Cfg.Repository, image.Tag)
-		body, _ := sendHTTPRequest(manifestURLParsed, token, "GET", false)
+// deleteChildren deletes the platform manifests of a deleted manifest list which no kept tag
+// of the repository references. Manifests other lists share are deleted once.
+func deleteChildren(image *Image, deleting map[*Image]bool, deletedChildren map[string]bool) error {
+	if len(image.Children) == 0 || len(image.Repository.Images) == 0 {
+		return nil
+	}
 
-		// Extract image tags from response
-		var data map[string]interface{}
-		if err := json.Unmarshal(body, &data); err != nil {
-			panic(err)
+	// Get the manifests the kept tags reference, resolveDeletionDigests resolved them
+	referenced := keptDigests(image.Repository, deleting)
+
+	// Delete unreferenced child manifests
+	for _, digest := range image.Children {
+		if referenced[digest] || deletedChildren[digest] {
+			continue
+		}
+		deletedChildren[digest] = true
+		manifestURLParsed := fmt.Sprintf(manifestURL, Cfg.RegistryURL, registryPath(image.Name), digest)
+		_, resp, err := sendHTTPRequest(manifestURLParsed, image.Repository.Token, "DELETE", true)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode == http.StatusAccepted || resp.StatusCode == http.StatusOK {
+			logf(lineDeleted, "Manifest deleted: %s@%s\n", image.Name, digest)
+		}
+	}
+	return nil
+}
+
+func setImageDigest(images []*Image) error {
+	defer timePhase("fetch digests")()
+	bar := newProgress("Fetching digests", len(images))
+	defer bar.finish()
+	return forEach(Cfg.Workers, len(images), func(id int) error {
+		image := images[id]
+		defer bar.add(1)
+		if image.Digest != "" {
+			return nil
+		}
+		// Get manifest
+		m, resp, err := getManifest(image.Repository, image.Tag)
+		if err != nil {
+			return withContext(err, image.Name, image.Tag)
 		}
 
-		// Get history
-		history := data["history"].([]interface{})
+		// Get digest and size
+		_, err = image.setManifest(m, resp)
+		return withContext(err, image.Name, image.Tag)
+	})
+}
 
-		// Get first history entry (always the newest) which is the last layer
-		lastLayer := history[0].(map[string]interface{})
+// resolveDigests sets the digest of images without one using HEAD requests, which don't download
+// the manifest. Manifest lists are fetched since the digests of their platforms are needed as well.
+func resolveDigests(images []*Image) error {
+	defer timePhase("fetch digests")()
+	bar := newProgress("Resolving digests", len(images))
+	defer bar.finish()
+	return forEach(Cfg.Workers, len(images), func(id int) error {
+		image := images[id]
+		defer bar.add(1)
+		if image.Digest != "" {
+			return nil
+		}
+		digest, mediaType, err := headManifest(image.Repository, image.Tag)
+		if err != nil {
+			return withContext(err, image.Name, image.Tag)
+		}
+		if digest != "" && mediaType != "" && !isIndex(mediaType) {
+			image.Digest = digest
+			return nil
+		}
 
-		// Get v1 Compatibility
-		compJSON := lastLayer["v1Compatibility"].(string)
+		// Fall back to the manifest if the type is unknown or the registry doesn't send the digest
+		m, resp, err := getManifest(image.Repository, image.Tag)
+		if err != nil {
+			return withContext(err, image.Name, image.Tag)
+		}
+		_, err = image.setManifest(m, resp)
+		return withContext(err, image.Name, image.Tag)
+	})
+}
 
-		// Get created field value
-		var comp map[string]interface{}
-		if err := json.Unmarshal([]byte(compJSON), &comp); err != nil {
-			panic(err)
+func setImageUploadDate(repo *Repository, images []*Image) error {
+	defer timePhase("fetch manifests")()
+	bar := newProgress("Fetching manifests", len(images))
+	defer bar.finish()
+	return forEach(Cfg.Workers, len(images), func(id int) error {
+		image := images[id]
+		defer bar.add(1)
+
+		// A failed manifest keeps the image, its age is unknown
+		ok, err := tolerate("fetch manifest", image.Name, image.Tag, func() error { return setUploadDate(repo, image) })
+		if !ok {
+			image.Result = "failed"
+			image.Reason = "manifest not fetched"
 		}
+		return err
+	})
+}
 
-		// Parse time
-		t, err := time.Parse(time.RFC3339, comp["created"].(string))
+// setUploadDate sets the creation time and the manifest details of the image
+func setUploadDate(repo *Repository, image *Image) error {
+	// Manifests are immutable, the digest is enough to find them in the cache
+	if Cfg.MetadataCache != "" {
+		digest, _, err := headManifest(repo, image.Tag)
 		if err != nil {
-			panic(err)
+			return err
+		}
+		if digest != "" {
+			image.Digest = digest
+			if ok, err := loadCachedMetadata(image); ok || err != nil {
+				return err
+			}
 		}
+	}
 
-		// Save time
-		images[id].Created = t
+	// Get the schema 2 or OCI manifest
+	m, resp, err := getManifest(repo, image.Tag)
+	if err != nil {
+		return err
 	}
-}
 
-func getImages(token string) []*Image {
-	// Create request
-	listTagsURL := fmt.Sprintf(imageTagsURL, Cfg.RegistryURL, Cfg.Repository)
-	body, _ := sendHTTPRequest(listTagsURL, token, "GET", false)
+	// Registries without schema 2 support send schema 1 manifests
+	if m.SchemaVersion == 1 {
+		image.Created, err = m.schema1Created()
+		return err
+	}
 
-	// Extract image tags from response
-	var data map[string]interface{}
-	if err := json.Unmarshal(body, &data); err != nil {
-		panic(err)
+	// Get the created field of the config blob
+	children, err := image.setManifest(m, resp)
+	if err != nil {
+		return err
 	}
+	if children != nil {
+		// Manifest lists have no config, use the newest platform
+		if image.Created, err = indexCreated(repo, children); err != nil {
+			return err
+		}
+	} else if m.Config.MediaType != helmConfigMediaType {
+		config, err := getImageConfig(repo, m)
+		if err != nil {
+			return err
+		}
+		image.Created = config.Created
+	}
+	if image.Created.IsZero() {
+		// Helm charts and other OCI artifacts have no created field in their config
+		image.Created, _ = time.Parse(time.RFC3339, m.Annotations[createdAnnotation])
+	}
+	return storeMetadata(image)
+}
 
-	// Get tags and image
+// getImages returns an image for every tag of the repository. The tag list is read
+// page by page, so only one page of the response is in memory at a time.
+func getImages(repo *Repository) ([]*Image, error) {
+	defer timePhase("list tags")()
 	var images []*Image
-	imageTags := data["tags"].([]interface{})
-	for _, tag := range imageTags {
-		images = append(images, &Image{
-			Name: Cfg.Repository,
-			Tag:  tag.(string),
-		})
+	pageURL := fmt.Sprintf(imageTagsURL, Cfg.RegistryURL, registryPath(repo.Name))
+	for pageURL != "" {
+		// Create request
+		body, resp, err := sendHTTPRequest(pageURL, repo.Token, "GET", false)
+		if err != nil {
+			return nil, err
+		}
+
+		// Extract image tags from response
+		var data struct {
+			Tags []string `json:"tags"`
+		}
+		if err := json.Unmarshal(body, &data); err != nil {
+			return nil, fmt.Errorf("invalid tag list of %s: %w", repo.Name, err)
+		}
+		for _, tag := range data.Tags {
+			images = append(images, &Image{
+				Name:       repo.Name,
+				Tag:        tag,
+				Repository: repo,
+			})
+		}
+		if pageURL, err = nextPageURL(resp); err != nil {
+			return nil, err
+		}
+	}
+	repo.TagCount = len(images)
+
+	// Remember all images for the results
+	repo.Images = append([]*Image{}, images...)
+	scannedRepositories = append(scannedRepositories, repo)
+	return images, nil
+}
+
+func getRegistryToken(repo *Repository) (string, error) {
+	return getRegistryTokenForScopes(fmt.Sprintf("repository:%s:*", repo.Name))
+}
+
+func getRegistryTokenForScopes(scopes ...string) (string, error) {
+	token, err := cachedToken(scopes)
+	if err != nil {
+		return "", err
+	}
+	if token == "" {
+		if token, err = fetchRegistryToken(scopes); err != nil {
+			return "", err
+		}
+		if err := cacheToken(scopes, token); err != nil {
+			return "", err
+		}
 	}
-	return images
+	rememberToken(token, scopes)
+	return token, nil
 }
 
-func getRegistryToken() string {
+func fetchRegistryToken(scopes []string) (string, error) {
 	// Create request
-	tokenURL := fmt.Sprintf(registryTokenURL, Cfg.GitlabURL, Cfg.Repository)
+	tokenURL := fmt.Sprintf(registryTokenURL, Cfg.GitlabURL)
+	for _, scope := range scopes {
+		tokenURL += "&scope=" + url.QueryEscape(scope)
+	}
 	req, err := http.NewRequest("GET", tokenURL, nil)
 	if err != nil {
-		panic(err)
+		return "", err
 	}
-	req.SetBasicAuth(Cfg.Username, Cfg.Password)
-	cli := &http.Client{}
+	req.SetBasicAuth(registryCredentials())
 
 	// Send request
-	resp, err := cli.Do(req)
+	resp, err := httpClient.Do(req)
 	if err != nil {
-		panic(err)
+		return "", err
 	}
 	defer resp.Body.Close()
 
 	// Get response from body
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		panic(err)
+		return "", err
 	}
 
 	// Validate response
 	if resp.StatusCode != http.StatusOK {
-		fmt.Printf("Return code: %d\n", resp.StatusCode)
-		fmt.Printf("Message: %s", string(body[:]))
-		panic("wrong username/password or repository combination")
+		return "", fmt.Errorf("cannot get registry token for %s, wrong username/password or repository combination: %w", strings.Join(scopes, " "), newResponseError(resp, body))
 	}
 
 	// Extract token from response
 	var data map[string]interface{}
 	if err := json.Unmarshal(body, &data); err != nil {
-		panic(err)
+		return "", fmt.Errorf("invalid registry token response: %w", err)
+	}
+	token, ok := data["token"].(string)
+	if !ok {
+		return "", errors.New("registry token response contains no token, check -giturl")
 	}
-	return data["token"].(string)
+	return token, nil
 }
 
-func sendHTTPRequest(url, token, method string, h bool) ([]byte, *http.Response) {
-	req, err := http.NewRequest(method, url, nil)
+func sendHTTPRequest(url, token, method string, h bool) ([]byte, *http.Response, error) {
+	body, resp, err := doHTTPRequest(url, token, method, h)
 	if err != nil {
-		panic(err)
+		return nil, nil, err
 	}
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+
+	// Validate response
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+		if resp.StatusCode == http.StatusNotFound || (method == "DELETE" && resp.StatusCode == http.StatusForbidden) {
+			fmt.Printf("Return code: %d\n", resp.StatusCode)
+		} else {
+			return nil, nil, newResponseError(resp, body)
+		}
+	}
+
+	return body, resp, nil
+}
+
+func doHTTPRequest(url, token, method string, h bool) ([]byte, *http.Response, error) {
+	header := map[string]string{}
 	if h {
-		req.Header.Set("Accept", "application/vnd.docker.distribution.manifest.v2+json")
+		header["Accept"] = strings.Join(manifestMediaTypes, ", ")
+	}
+	return doRegistryRequest(url, token, method, header, nil)
+}
+
+func doRegistryRequest(url, token, method string, header map[string]string, data io.Reader) ([]byte, *http.Response, error) {
+	req, err := http.NewRequest(method, url, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	for key, value := range header {
+		req.Header.Set(key, value)
 	}
-	cli := &http.Client{}
 
 	// Send request
-	resp, err := cli.Do(req)
+	resp, err := httpClient.Do(req)
 	if err != nil {
-		panic(err)
+		return nil, nil, err
 	}
 	defer resp.Body.Close()
 
 	// Get response from body
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		panic(err)
+		return nil, nil, err
 	}
-
-	// Validate response
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
-		if resp.StatusCode == http.StatusNotFound {
-			fmt.Printf("Return code: %d\n", resp.StatusCode)
-		} else {
-			fmt.Printf("Return code: %d\n", resp.StatusCode)
-			fmt.Printf("Message: %s", string(body[:]))
-			panic("error")
-		}
-	}
-
-	return body, resp
+	return body, resp, nil
 }
 
 func (k *kubeConfigFlags) Set(value string) error {
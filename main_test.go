@@ -0,0 +1,158 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setConfig replaces the configuration for the test
+func setConfig(t *testing.T, cfg Config) {
+	old := *Cfg
+	cfg.Quiet = true
+	*Cfg = cfg
+	t.Cleanup(func() { *Cfg = old })
+}
+
+// imageTags returns the tags of the images
+func imageTags(images []*Image) []string {
+	var tags []string
+	for _, image := range images {
+		tags = append(tags, image.Tag)
+	}
+	return tags
+}
+
+func TestLimitDeletions(t *testing.T) {
+	tests := []struct {
+		name   string
+		cfg    Config
+		max    int
+		tags   []string
+		reason string
+		err    string
+	}{
+		{name: "no limits", tags: []string{"1", "2", "3", "4"}},
+		{name: "below maximum", max: 4, tags: []string{"1", "2", "3", "4"}},
+		{name: "above maximum", max: 3, err: "4 images exceed the maximum of 3 deletions"},
+		{name: "truncated to the oldest", cfg: Config{TruncateDeletions: true}, max: 2, tags: []string{"1", "2"}, reason: "exceeds maximum deletions"},
+		{name: "below percent", cfg: Config{MaxDeletePercent: 50}, tags: []string{"1", "2", "3", "4"}},
+		{name: "above percent", cfg: Config{MaxDeletePercent: 30}, err: "too many tags would be deleted"},
+		{name: "above percent with force", cfg: Config{MaxDeletePercent: 30, Force: true}, tags: []string{"1", "2", "3", "4"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.cfg.Strategy = "age"
+			setConfig(t, test.cfg)
+
+			// 4 of 8 tags, newest first
+			repo := &Repository{Name: "app", TagCount: 8}
+			var images []*Image
+			for i, tag := range []string{"4", "3", "2", "1"} {
+				images = append(images, &Image{Name: "app", Tag: tag, Created: time.Now().AddDate(0, 0, -i-1), Repository: repo})
+			}
+			all := append([]*Image(nil), images...)
+
+			got, err := limitDeletions(images, test.max)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tags := imageTags(got); !sameTags(tags, test.tags) {
+				t.Errorf("expected %v, got %v", test.tags, tags)
+			}
+			for _, image := range all {
+				if !contains(got, image) && image.Reason != test.reason {
+					t.Errorf("expected reason %q for %s, got %q", test.reason, image.Tag, image.Reason)
+				}
+			}
+		})
+	}
+}
+
+func TestKeepSharedDigests(t *testing.T) {
+	tests := []struct {
+		name     string
+		deleting []string
+		deleted  []string
+	}{
+		{name: "tag shares digest with kept tag", deleting: []string{"a"}},
+		{name: "all tags of digest deleted", deleting: []string{"a", "b"}, deleted: []string{"a", "b"}},
+		{name: "unshared digest", deleting: []string{"c"}, deleted: []string{"c"}},
+		{name: "platform of kept manifest list", deleting: []string{"amd64"}},
+		{name: "platform of deleted manifest list", deleting: []string{"list", "amd64"}, deleted: []string{"list", "amd64"}},
+		{name: "mixed", deleting: []string{"a", "c", "amd64"}, deleted: []string{"c"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setConfig(t, Config{})
+			repo := &Repository{Name: "app"}
+			digests := map[string]string{"a": "sha256:1", "b": "sha256:1", "c": "sha256:2", "list": "sha256:3", "amd64": "sha256:4"}
+			byTag := make(map[string]*Image)
+			for _, tag := range []string{"a", "b", "c", "list", "amd64"} {
+				image := &Image{Name: "app", Tag: tag, Digest: digests[tag], Repository: repo}
+				repo.Images = append(repo.Images, image)
+				byTag[tag] = image
+			}
+			byTag["list"].Children = []string{"sha256:4"}
+
+			var images []*Image
+			deleting := make(map[*Image]bool)
+			for _, tag := range test.deleting {
+				byTag[tag].Result = "delete"
+				images = append(images, byTag[tag])
+				deleting[byTag[tag]] = true
+			}
+
+			got := keepSharedDigests(images, deleting)
+			if tags := imageTags(got); !reflect.DeepEqual(tags, test.deleted) {
+				t.Errorf("expected %v, got %v", test.deleted, tags)
+			}
+			for _, tag := range test.deleting {
+				image := byTag[tag]
+				if contains(got, image) != deleting[image] {
+					t.Errorf("%s is in the result but deleting is %v", tag, deleting[image])
+				}
+				if !contains(got, image) && (image.Result != "" || image.Reason != "digest shared with kept tag") {
+					t.Errorf("expected %s to be kept, got result %q and reason %q", tag, image.Result, image.Reason)
+				}
+			}
+		})
+	}
+}
+
+// contains returns true if the image is in the slice
+func contains(images []*Image, image *Image) bool {
+	for _, i := range images {
+		if i == image {
+			return true
+		}
+	}
+	return false
+}
+
+// sameTags returns true if both lists contain the same tags in any order
+func sameTags(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	count := make(map[string]int)
+	for _, tag := range a {
+		count[tag]++
+	}
+	for _, tag := range b {
+		count[tag]--
+	}
+	for _, c := range count {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
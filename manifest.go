@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	blobURL = "%s/v2/%s/blobs/%s"
+
+	manifestV2MediaType    = "application/vnd.docker.distribution.manifest.v2+json"
+	manifestListMediaType  = "application/vnd.docker.distribution.manifest.list.v2+json"
+	ociManifestMediaType   = "application/vnd.oci.image.manifest.v1+json"
+	ociIndexMediaType      = "application/vnd.oci.image.index.v1+json"
+	helmConfigMediaType    = "application/vnd.cncf.helm.config.v1+json"
+	createdAnnotation      = "org.opencontainers.image.created"
+	foreignLayerMediaType  = "application/vnd.docker.image.rootfs.foreign.diff.tar.gzip"
+	nonDistributablePrefix = "application/vnd.oci.image.layer.nondistributable"
+)
+
+// Descriptor references a blob in the registry
+type Descriptor struct {
+	MediaType    string            `json:"mediaType"`
+	ArtifactType string            `json:"artifactType,omitempty"`
+	Size         int64             `json:"size"`
+	Digest       string            `json:"digest"`
+	URLs         []string          `json:"urls,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+	Platform     *Platform         `json:"platform,omitempty"`
+}
+
+// Manifest represents a schema 2 image manifest or, with child manifests, a manifest list.
+// Registries without schema 2 support send schema 1 manifests with history instead.
+type Manifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType"`
+	ArtifactType  string            `json:"artifactType,omitempty"`
+	Config        Descriptor        `json:"config"`
+	Layers        []Descriptor      `json:"layers"`
+	Manifests     []Descriptor      `json:"manifests,omitempty"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+	History       []struct {
+		V1Compatibility string `json:"v1Compatibility"`
+	} `json:"history,omitempty"`
+}
+
+// ImageConfig represents the fields of an image config blob we care about
+type ImageConfig struct {
+	Created      time.Time `json:"created"`
+	OS           string    `json:"os"`
+	Architecture string    `json:"architecture"`
+}
+
+// foreign returns true if the layer is not stored in the registry (e.g. Windows base layers)
+func (d Descriptor) foreign() bool {
+	return d.MediaType == foreignLayerMediaType || strings.HasPrefix(d.MediaType, nonDistributablePrefix)
+}
+
+// blobs returns the config and layer blobs stored in the registry for this manifest. Foreign layers are ignored.
+func (m *Manifest) blobs() []Descriptor {
+	var blobs []Descriptor
+	if m.Config.Digest != "" {
+		blobs = append(blobs, m.Config)
+	}
+	for _, layer := range m.Layers {
+		if !layer.foreign() {
+			blobs = append(blobs, layer)
+		}
+	}
+	return blobs
+}
+
+// schema1Created returns the created field of the first history entry of a schema 1 manifest,
+// which is always the newest
+func (m *Manifest) schema1Created() (time.Time, error) {
+	if len(m.History) == 0 {
+		return time.Time{}, errors.New("schema 1 manifest has no history")
+	}
+	comp := &ImageConfig{}
+	if err := json.Unmarshal([]byte(m.History[0].V1Compatibility), comp); err != nil {
+		return time.Time{}, err
+	}
+	return comp.Created, nil
+}
+
+// getManifest fetches the schema 2 manifest of the given reference
+func getManifest(repo *Repository, reference string) (*Manifest, *http.Response, error) {
+	// Create request
+	manifestURLParsed := fmt.Sprintf(manifestURL, Cfg.RegistryURL, registryPath(repo.Name), reference)
+	body, resp, err := sendHTTPRequest(manifestURLParsed, repo.Token, "GET", true)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Extract manifest from response
+	m := &Manifest{}
+	if err := json.Unmarshal(body, m); err != nil {
+		return nil, nil, fmt.Errorf("invalid manifest %s:%s: %w", repo.Name, reference, err)
+	}
+	return m, resp, nil
+}
+
+// headManifest returns the digest and media type of the reference without downloading the manifest.
+// The media type is empty if the registry sends a generic one.
+func headManifest(repo *Repository, reference string) (string, string, error) {
+	manifestURLParsed := fmt.Sprintf(manifestURL, Cfg.RegistryURL, registryPath(repo.Name), reference)
+	_, resp, err := sendHTTPRequest(manifestURLParsed, repo.Token, "HEAD", true)
+	if err != nil {
+		return "", "", err
+	}
+	return resp.Header.Get("Docker-Content-Digest"), specificMediaType(resp.Header.Get("Content-Type")), nil
+}
+
+// getImageConfig fetches the config blob referenced by the manifest
+func getImageConfig(repo *Repository, m *Manifest) (*ImageConfig, error) {
+	body, err := getBlob(repo, m.Config.Digest)
+	if err != nil {
+		return nil, err
+	}
+
+	// Extract config from response
+	c := &ImageConfig{}
+	if err := json.Unmarshal(body, c); err != nil {
+		return nil, fmt.Errorf("invalid config %s of %s: %w", m.Config.Digest, repo.Name, err)
+	}
+	return c, nil
+}
+
+// getBlob fetches the blob with the digest
+func getBlob(repo *Repository, digest string) ([]byte, error) {
+	blobURLParsed := fmt.Sprintf(blobURL, Cfg.RegistryURL, registryPath(repo.Name), digest)
+	body, _, err := sendHTTPRequest(blobURLParsed, repo.Token, "GET", false)
+	return body, err
+}
+
+// setImageMediaType sets the media types of images whose manifest wasn't fetched yet
+func setImageMediaType(images []*Image) error {
+	for _, image := range images {
+		if image.MediaType != "" {
+			continue
+		}
+
+		// Get manifest
+		m, resp, err := getManifest(image.Repository, image.Tag)
+		if err != nil {
+			return withContext(err, image.Name, image.Tag)
+		}
+		if _, err := image.setManifest(m, resp); err != nil {
+			return withContext(err, image.Name, image.Tag)
+		}
+	}
+	return nil
+}
+
+// setManifest sets digest, media types, size and blobs of the image from its manifest.
+// The child manifests of a manifest list are fetched and returned, the image then
+// has the blobs of all platforms.
+func (image *Image) setManifest(m *Manifest, resp *http.Response) ([]*Manifest, error) {
+	image.Digest = resp.Header.Get("Docker-Content-Digest")
+	image.MediaType = manifestMediaType(resp.Header.Get("Content-Type"), m)
+	image.ArtifactType = m.ArtifactType
+	image.ConfigType = m.Config.MediaType
+	image.Blobs = m.blobs()
+	image.Children = nil
+
+	// Add the blobs of all platforms, blobs shared by platforms only once
+	var children []*Manifest
+	if isIndex(image.MediaType) {
+		seen := make(map[string]bool)
+		for _, desc := range m.Manifests {
+			child, _, err := getManifest(image.Repository, desc.Digest)
+			if err != nil {
+				return nil, err
+			}
+			children = append(children, child)
+			image.Children = append(image.Children, desc.Digest)
+			for _, blob := range child.blobs() {
+				if !seen[blob.Digest] {
+					seen[blob.Digest] = true
+					image.Blobs = append(image.Blobs, blob)
+				}
+			}
+		}
+	}
+
+	image.Size = 0
+	for _, blob := range image.Blobs {
+		image.Size += blob.Size
+	}
+	return children, nil
+}
+
+// manifestMediaType returns the media type of the manifest from the Content-Type header.
+// OCI manifests don't need a media type, registries then send a generic or no header.
+func manifestMediaType(contentType string, m *Manifest) string {
+	if mediaType := specificMediaType(contentType); mediaType != "" {
+		return mediaType
+	}
+	switch {
+	case m.MediaType != "":
+		return m.MediaType
+	case len(m.Manifests) > 0:
+		return ociIndexMediaType
+	default:
+		return ociManifestMediaType
+	}
+}
+
+// specificMediaType returns the media type of the Content-Type header without parameters,
+// empty if it is a generic type like application/json
+func specificMediaType(contentType string) string {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil && strings.HasPrefix(mediaType, "application/vnd.") {
+		return mediaType
+	}
+	return ""
+}
+
+// indexCreated returns the newest creation date of the platform images of a manifest list
+func indexCreated(repo *Repository, children []*Manifest) (time.Time, error) {
+	var created time.Time
+	for _, child := range children {
+		if child.Config.Digest == "" {
+			continue
+		}
+		config, err := getImageConfig(repo, child)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if config.Created.After(created) {
+			created = config.Created
+		}
+	}
+	return created, nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+const metadataCacheSchema = `
+CREATE TABLE IF NOT EXISTS manifests (
+	digest TEXT PRIMARY KEY,
+	media_type TEXT NOT NULL,
+	artifact_type TEXT NOT NULL,
+	config_type TEXT NOT NULL,
+	created TEXT NOT NULL,
+	size INTEGER NOT NULL,
+	blobs TEXT NOT NULL,
+	children TEXT NOT NULL
+);
+`
+
+// metadataCache is the -metadata-cache database opened on first use
+var metadataCache struct {
+	db *sql.DB
+	sync.Mutex
+}
+
+// openMetadataCache opens the metadata cache and creates the table if needed.
+// The lock must be held.
+func openMetadataCache() (*sql.DB, error) {
+	if metadataCache.db == nil {
+		db, err := sql.Open("sqlite3", Cfg.MetadataCache)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := db.Exec(metadataCacheSchema); err != nil {
+			return nil, err
+		}
+		metadataCache.db = db
+	}
+	return metadataCache.db, nil
+}
+
+// loadCachedMetadata sets created date, size, media types and blobs of the image from the
+// cache entry of its digest. Returns false if the digest is not cached.
+func loadCachedMetadata(image *Image) (bool, error) {
+	metadataCache.Lock()
+	defer metadataCache.Unlock()
+	db, err := openMetadataCache()
+	if err != nil {
+		return false, err
+	}
+	var created, blobs, children string
+	row := db.QueryRow(`SELECT media_type, artifact_type, config_type, created, size, blobs, children FROM manifests WHERE digest = ?`, image.Digest)
+	err = row.Scan(&image.MediaType, &image.ArtifactType, &image.ConfigType, &created, &image.Size, &blobs, &children)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	image.Created = time.Time{}
+	if created != "" {
+		if image.Created, err = time.Parse(time.RFC3339, created); err != nil {
+			return false, err
+		}
+	}
+	if err := json.Unmarshal([]byte(blobs), &image.Blobs); err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal([]byte(children), &image.Children); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// storeMetadata adds created date, size, media types and blobs of the image to the cache
+func storeMetadata(image *Image) error {
+	if Cfg.MetadataCache == "" || image.Digest == "" {
+		return nil
+	}
+	metadataCache.Lock()
+	defer metadataCache.Unlock()
+	db, err := openMetadataCache()
+	if err != nil {
+		return err
+	}
+	var created string
+	if !image.Created.IsZero() {
+		created = image.Created.UTC().Format(time.RFC3339)
+	}
+	blobs, err := json.Marshal(image.Blobs)
+	if err != nil {
+		return err
+	}
+	children, err := json.Marshal(image.Children)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`INSERT OR REPLACE INTO manifests (digest, media_type, artifact_type, config_type, created, size, blobs, children) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		image.Digest, image.MediaType, image.ArtifactType, image.ConfigType, created, image.Size, string(blobs), string(children))
+	return err
+}
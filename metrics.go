@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Accumulated durations of the phases of the run
+var (
+	phaseDurations = make(map[string]time.Duration)
+	phaseLock      sync.Mutex
+)
+
+// timePhase measures a phase until the returned function is called, e.g. defer timePhase("delete")()
+func timePhase(phase string) func() {
+	start := time.Now()
+	s := startSpan(phase)
+	previous := setErrorPhase(phase)
+	return func() {
+		setErrorPhase(previous)
+		s.finish()
+		phaseLock.Lock()
+		defer phaseLock.Unlock()
+		phaseDurations[phase] += time.Since(start)
+	}
+}
+
+// formatMetrics returns the summary in the Prometheus text exposition format
+func formatMetrics(s Summary) string {
+	var b strings.Builder
+	metric := func(name, help string, value interface{}) {
+		fmt.Fprintf(&b, "# HELP registry_pruner_%s %s\n# TYPE registry_pruner_%s gauge\nregistry_pruner_%s %v\n", name, help, name, name, value)
+	}
+	metric("repositories", "Number of repositories scanned by the last run.", s.Repositories)
+	metric("tags_scanned", "Number of tags scanned by the last run.", s.TagsScanned)
+	metric("tags_deleted", "Number of tags deleted by the last run, or to delete if it was a dry run.", s.TagsDeleted)
+	metric("bytes_reclaimed", "Size of the manifests and layers of the deleted tags of the last run.", s.BytesReclaimed)
+	metric("failures", "Number of failed deletions of the last run.", s.Failures)
+	metric("error", "1 if the last run was aborted by an error.", boolMetric(s.Error != ""))
+	metric("dry_run", "1 if the last run was a dry run.", boolMetric(s.DryRun))
+	metric("duration_seconds", "Duration of the last run.", s.Seconds)
+	metric("last_run_timestamp_seconds", "Time the last run finished.", s.Finished.Unix())
+
+	// Phase timings
+	var phases []string
+	for phase := range s.Phases {
+		phases = append(phases, phase)
+	}
+	sort.Strings(phases)
+	b.WriteString("# HELP registry_pruner_phase_duration_seconds Duration of the phases of the last run.\n")
+	b.WriteString("# TYPE registry_pruner_phase_duration_seconds gauge\n")
+	for _, phase := range phases {
+		fmt.Fprintf(&b, "registry_pruner_phase_duration_seconds{phase=%q} %v\n", phase, s.Phases[phase])
+	}
+	return b.String()
+}
+
+func boolMetric(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// pushMetrics replaces the metrics of the job in the Prometheus Pushgateway
+func pushMetrics(s Summary) error {
+	pushURL := strings.TrimRight(Cfg.PushgatewayURL, "/") + "/metrics/job/" + url.PathEscape(Cfg.PushgatewayJob)
+	req, err := http.NewRequest("PUT", pushURL, bytes.NewBufferString(formatMetrics(s)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+	// Send request
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("return code %d: %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
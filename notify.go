@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// startTime is used to report the duration of the run
+var startTime = time.Now()
+
+// Summary is the outcome of a run sent to the notification targets
+type Summary struct {
+	DryRun         bool               `json:"dry_run"`
+	Repositories   int                `json:"repositories"`
+	TagsScanned    int                `json:"tags_scanned"`
+	TagsDeleted    int                `json:"tags_deleted"`
+	BytesReclaimed int64              `json:"bytes_reclaimed"`
+	Failures       int                `json:"failures"`
+	Error          string             `json:"error,omitempty"`
+	Finished       time.Time          `json:"finished"`
+	Duration       time.Duration      `json:"-"`
+	Seconds        float64            `json:"duration_seconds"`
+	Phases         map[string]float64 `json:"phases_seconds"`
+}
+
+// runSummary summarizes the decisions of all scanned repositories
+func runSummary() Summary {
+	s := Summary{
+		DryRun:       !Cfg.DeleteImages,
+		Repositories: len(scannedRepositories),
+		Failures:     failedCount,
+		Finished:     time.Now(),
+		Duration:     time.Since(startTime).Round(time.Second),
+	}
+	s.Seconds = s.Duration.Seconds()
+
+	// Phase timings
+	s.Phases = make(map[string]float64)
+	phaseLock.Lock()
+	for phase, d := range phaseDurations {
+		s.Phases[phase] = d.Seconds()
+	}
+	phaseLock.Unlock()
+
+	var removed []*Image
+	for _, repo := range scannedRepositories {
+		s.TagsScanned += repo.TagCount
+		for _, image := range repo.Images {
+			if image.removed() {
+				removed = append(removed, image)
+			}
+		}
+	}
+	s.TagsDeleted = len(removed)
+	s.BytesReclaimed, _ = reclaimableBytes(removed)
+	return s
+}
+
+// text returns the summary as a single line
+func (s Summary) text() string {
+	verb := "deleted"
+	if s.DryRun {
+		verb = "to delete (dry run)"
+	}
+	text := fmt.Sprintf("Registry prune finished in %s: %d repositories, %d tags scanned, %d tags %s, %s reclaimed, %d failures",
+		s.Duration, s.Repositories, s.TagsScanned, s.TagsDeleted, verb, formatBytes(s.BytesReclaimed), s.Failures)
+	if s.Error != "" {
+		text += ", error: " + s.Error
+	}
+	return text
+}
+
+// teamsMessage returns the summary as adaptive card message for Microsoft Teams
+func (s Summary) teamsMessage() map[string]interface{} {
+	title := "Registry prune finished"
+	if s.DryRun {
+		title += " (dry run)"
+	}
+	facts := []map[string]string{
+		{"title": "Repositories", "value": fmt.Sprint(s.Repositories)},
+		{"title": "Tags scanned", "value": fmt.Sprint(s.TagsScanned)},
+		{"title": "Tags deleted", "value": fmt.Sprint(s.TagsDeleted)},
+		{"title": "Reclaimed", "value": formatBytes(s.BytesReclaimed)},
+		{"title": "Failures", "value": fmt.Sprint(s.Failures)},
+		{"title": "Duration", "value": s.Duration.String()},
+	}
+	body := []map[string]interface{}{
+		{"type": "TextBlock", "text": title, "size": "Medium", "weight": "Bolder"},
+		{"type": "FactSet", "facts": facts},
+	}
+	if s.Error != "" {
+		body = append(body, map[string]interface{}{"type": "TextBlock", "text": "Error: " + s.Error, "color": "Attention", "wrap": true})
+	}
+
+	return map[string]interface{}{
+		"type": "message",
+		"attachments": []map[string]interface{}{{
+			"contentType": "application/vnd.microsoft.card.adaptive",
+			"content": map[string]interface{}{
+				"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+				"type":    "AdaptiveCard",
+				"version": "1.4",
+				"body":    body,
+			},
+		}},
+	}
+}
+
+// notify sends the summary of the run to all configured targets, including the error the run failed with
+func notify(err error) {
+	s := runSummary()
+	if err != nil {
+		s.Error = err.Error()
+	}
+
+	if Cfg.SlackWebhook != "" {
+		sendNotification("Slack", func() error {
+			return postJSON(Cfg.SlackWebhook, map[string]string{"text": s.text()})
+		})
+	}
+
+	if Cfg.TeamsWebhook != "" {
+		sendNotification("Teams", func() error {
+			return postJSON(Cfg.TeamsWebhook, s.teamsMessage())
+		})
+	}
+
+	if Cfg.SMTPServer != "" && Cfg.SMTPTo != "" {
+		sendNotification("email", func() error {
+			return sendEmail(s)
+		})
+	}
+
+	if Cfg.NotifyURL != "" {
+		sendNotification("webhook", func() error {
+			return sendWebhook(s)
+		})
+	}
+
+	if Cfg.IssueProject != "" && !s.DryRun && s.TagsDeleted > 0 {
+		sendNotification("GitLab issue", func() error {
+			return reportIssue(s)
+		})
+	}
+
+	if Cfg.PushgatewayURL != "" {
+		sendNotification("Pushgateway", func() error {
+			return pushMetrics(s)
+		})
+	}
+
+	if Cfg.StatsdAddress != "" {
+		sendNotification("statsd", func() error {
+			return sendStatsd(s)
+		})
+	}
+
+	if Cfg.HistoryDB != "" {
+		sendNotification("history", func() error {
+			return saveHistory(s)
+		})
+	}
+}
+
+// sendWebhook posts the summary and the deleted images as JSON. If a secret is given the
+// body is signed with HMAC-SHA256 in the X-Signature-256 header.
+func sendWebhook(s Summary) error {
+	type webhookImage struct {
+		Repository string `json:"repository"`
+		Tag        string `json:"tag"`
+		Digest     string `json:"digest"`
+		Size       int64  `json:"size"`
+		Decision   string `json:"decision"`
+	}
+	payload := struct {
+		Event   string         `json:"event"`
+		Summary Summary        `json:"summary"`
+		Images  []webhookImage `json:"images"`
+	}{Event: "registry_prune", Summary: s, Images: []webhookImage{}}
+	for _, repo := range scannedRepositories {
+		for _, image := range repo.Images {
+			if d := image.decision(); d != "keep" {
+				payload.Images = append(payload.Images, webhookImage{image.Name, image.Tag, image.Digest, image.Size, d})
+			}
+		}
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	// Create request
+	req, err := http.NewRequest("POST", Cfg.NotifyURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if Cfg.NotifySecret != "" {
+		mac := hmac.New(sha256.New, []byte(Cfg.NotifySecret))
+		mac.Write(data)
+		req.Header.Set("X-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	// Send request
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("return code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// sendNotification reports failed notifications without failing the run
+func sendNotification(target string, send func() error) {
+	if err := send(); err != nil {
+		fmt.Printf("Cannot send %s notification: %s\n", target, err.Error())
+	}
+}
+
+// postJSON posts the payload as JSON and fails if the response is no success
+func postJSON(url string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	resp, err := httpClient.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("return code %d: %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
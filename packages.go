@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	packagesURL               = "%s/api/v4/projects/%s/packages?package_type=%s&per_page=100&page=%s"
+	packageURL                = "%s/api/v4/projects/%s/packages/%d"
+	packageProtectionRulesURL = "%s/api/v4/projects/%s/packages/protection/rules"
+)
+
+// Package represents a package in the GitLab package registry
+type Package struct {
+	ID          int         `json:"id"`
+	Name        string      `json:"name"`
+	Version     string      `json:"version"`
+	PackageType string      `json:"package_type"`
+	CreatedAt   time.Time   `json:"created_at"`
+	Repository  *Repository `json:"-"`
+}
+
+// prunePackages applies the retention rules to the packages of the project
+func prunePackages() error {
+	// --- Get all packages of the requested types ---
+	var packages []*Package
+	protected := make(map[*Repository][]*regexp.Regexp)
+	for _, path := range Cfg.Repositories {
+		repo := newRepository(path)
+		for _, packageType := range strings.Split(Cfg.PackageTypes, ",") {
+			repoPackages, err := getPackages(repo, strings.TrimSpace(packageType))
+			if err != nil {
+				return err
+			}
+			packages = append(packages, repoPackages...)
+		}
+		patterns, err := getProtectedPackagePatterns(repo)
+		if err != nil {
+			return err
+		}
+		protected[repo] = patterns
+	}
+
+	// --- Remove packages which shall be kept ---
+	latest := getLatestPackages(packages)
+	i := 0
+	for _, pkg := range packages {
+		reason := keepPackage(pkg, protected[pkg.Repository])
+		if latest[pkg] {
+			reason = fmt.Sprintf("is one of the %d newest versions", pkg.Repository.keepLatest())
+		}
+		if reason != "" {
+			// Print information
+			kind := lineKept
+			if reason == protectedPackageReason {
+				kind = lineProtected
+			}
+			logf(kind, "Package %s %s (%s) %s, skipped\n", pkg.Name, pkg.Version, pkg.PackageType, reason)
+		} else {
+			// This package should stay in slice
+			packages[i] = pkg
+			i++
+		}
+	}
+	// Remove the rest
+	packages = packages[:i]
+
+	// --- Print resulting packages ---
+	for _, pkg := range packages {
+		logf(lineDeleted, "%sPackage will be deleted: %s %s (%s)\n", dryRunPrefix(), pkg.Name, pkg.Version, pkg.PackageType)
+	}
+	printDryRunNotice()
+
+	// --- Give the user the chance to think about it ---
+	if Cfg.DeleteImages {
+		if !Cfg.Yes {
+			if ok, err := confirmDeletion(); !ok {
+				return err
+			}
+		}
+
+		// Start delete process
+		fmt.Println("--- Starting delete process ---")
+		for _, pkg := range packages {
+			id, err := projectID(pkg.Repository)
+			if err != nil {
+				return err
+			}
+			_, resp, err := sendGitlabRequest(fmt.Sprintf(packageURL, Cfg.GitlabURL, id, pkg.ID), "DELETE", nil)
+			if err != nil {
+				return err
+			}
+			switch resp.StatusCode {
+			case http.StatusNoContent, http.StatusOK:
+				logf(lineDeleted, "Package deleted: %s %s (%s)\n", pkg.Name, pkg.Version, pkg.PackageType)
+				deletedCount++
+			case http.StatusForbidden:
+				fmt.Printf("Package %s %s (%s) is protected, skipped\n", pkg.Name, pkg.Version, pkg.PackageType)
+			default:
+				return fmt.Errorf("cannot delete package %s %s: %w", pkg.Name, pkg.Version, newResponseError(resp, nil))
+			}
+		}
+	}
+	return nil
+}
+
+// protectedPackageReason is the reason of packages GitLab protects
+const protectedPackageReason = "is protected by GitLab"
+
+// keepPackage returns the reason why the package must be kept or an empty string
+func keepPackage(pkg *Package, protected []*regexp.Regexp) string {
+	// Protected by GitLab
+	for _, pattern := range protected {
+		if pattern.MatchString(pkg.Name) {
+			return protectedPackageReason
+		}
+	}
+
+	// Matches keep or doesn't match delete regex pattern
+	if _, matched := pkg.Repository.keepRegexps().match(pkg.Version); matched {
+		return "matches keep regexp"
+	}
+	if _, matched := protectList.match(pkg.Version); matched {
+		return "is in the protect file"
+	}
+	if !isDeleteListed(pkg.Version, pkg.Repository.deleteRegexps()) {
+		return "does not match any delete regexp or delete file entry"
+	}
+
+	// Use the tag policy expiry if one matches the version
+	minExpiry := pkg.Repository.minExpiry()
+	if policy := Cfg.TagPolicies.match(pkg.Version); policy != nil {
+		minExpiry = policy.Days
+	}
+	if minExpiry < 0 {
+		return "is kept forever"
+	}
+	if !pkg.CreatedAt.Before(time.Now().AddDate(0, 0, minExpiry*-1)) {
+		return "is too young"
+	}
+	return ""
+}
+
+// getLatestPackages returns the newest versions of every package according to the keep latest policy
+func getLatestPackages(packages []*Package) map[*Package]bool {
+	// Sort by created date, newest first
+	sorted := make([]*Package, len(packages))
+	copy(sorted, packages)
+	sort.Slice(sorted, func(a, b int) bool {
+		return sorted[a].CreatedAt.After(sorted[b].CreatedAt)
+	})
+
+	// Count versions per package
+	latest := make(map[*Package]bool)
+	count := make(map[string]int)
+	for _, pkg := range sorted {
+		key := pkg.Repository.Name + "/" + pkg.PackageType + "/" + pkg.Name
+		if count[key] < pkg.Repository.keepLatest() {
+			latest[pkg] = true
+			count[key]++
+		}
+	}
+	return latest
+}
+
+func getPackages(repo *Repository, packageType string) ([]*Package, error) {
+	id, err := projectID(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	var packages []*Package
+	page := "1"
+	for page != "" {
+		// Create request
+		body, resp, err := sendGitlabRequest(fmt.Sprintf(packagesURL, Cfg.GitlabURL, id, packageType, page), "GET", nil)
+		if err != nil {
+			return nil, err
+		}
+
+		// Validate response
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("cannot list packages of %s: %w", repo.Name, newResponseError(resp, body))
+		}
+
+		// Extract packages from response
+		var pagePackages []*Package
+		if err := json.Unmarshal(body, &pagePackages); err != nil {
+			return nil, fmt.Errorf("invalid packages of %s: %w", repo.Name, err)
+		}
+		for _, pkg := range pagePackages {
+			pkg.Repository = repo
+		}
+		packages = append(packages, pagePackages...)
+		page = resp.Header.Get("X-Next-Page")
+	}
+	return packages, nil
+}
+
+// getProtectedPackagePatterns returns the package name patterns of the project's package protection rules
+func getProtectedPackagePatterns(repo *Repository) ([]*regexp.Regexp, error) {
+	id, err := projectID(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create request
+	body, resp, err := sendGitlabRequest(fmt.Sprintf(packageProtectionRulesURL, Cfg.GitlabURL, id), "GET", nil)
+	if err != nil {
+		fmt.Printf("Cannot get package protection rules: %s\n", err.Error())
+		return nil, nil
+	}
+
+	// Validate response. Older GitLab versions don't know this endpoint.
+	if resp.StatusCode != http.StatusOK {
+		fmt.Printf("Cannot get package protection rules, return code: %d\n", resp.StatusCode)
+		return nil, nil
+	}
+
+	// Extract rules from response
+	var rules []map[string]interface{}
+	if err := json.Unmarshal(body, &rules); err != nil {
+		return nil, fmt.Errorf("invalid package protection rules of %s: %w", repo.Name, err)
+	}
+
+	// Convert wildcard patterns
+	var patterns []*regexp.Regexp
+	for _, rule := range rules {
+		pattern, ok := rule["package_name_pattern"].(string)
+		if !ok {
+			continue
+		}
+		quoted := strings.Replace(regexp.QuoteMeta(pattern), `\*`, ".*", -1)
+		patterns = append(patterns, regexp.MustCompile("^"+quoted+"$"))
+	}
+	return patterns, nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// regexpFlags holds regex patterns given repeatedly or as comma separated list
+type regexpFlags []string
+
+// Set adds the comma separated patterns. Commas inside of (), [] and {} don't separate patterns.
+func (r *regexpFlags) Set(value string) error {
+	for _, pattern := range splitPatterns(value) {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return err
+		}
+		*r = append(*r, pattern)
+	}
+	return nil
+}
+
+func (r *regexpFlags) String() string {
+	return strings.Join(*r, ",")
+}
+
+// UnmarshalYAML accepts a single pattern or a list of patterns
+func (r *regexpFlags) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var list []string
+	if err := unmarshal(&list); err != nil {
+		var value string
+		if err := unmarshal(&value); err != nil {
+			return err
+		}
+		list = []string{value}
+	}
+
+	*r = regexpFlags{}
+	for _, value := range list {
+		if err := r.Set(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// regexpFlag holds a single regex pattern, compiled when the flag is set
+type regexpFlag struct {
+	*regexp.Regexp
+}
+
+func (r *regexpFlag) Set(value string) error {
+	re, err := regexp.Compile(value)
+	if err != nil {
+		return err
+	}
+	r.Regexp = re
+	return nil
+}
+
+func (r *regexpFlag) String() string {
+	if r == nil || r.Regexp == nil {
+		return ""
+	}
+	return r.Regexp.String()
+}
+
+// splitPatterns splits the value at commas which are not part of a group, class or repetition
+func splitPatterns(value string) []string {
+	var patterns []string
+	depth, start := 0, 0
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			i++
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				patterns = append(patterns, value[start:i])
+				start = i + 1
+			}
+		}
+	}
+	patterns = append(patterns, value[start:])
+
+	// Remove empty patterns
+	i := 0
+	for _, pattern := range patterns {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			patterns[i] = pattern
+			i++
+		}
+	}
+	return patterns[:i]
+}
+
+// Regexps is a list of compiled regex patterns
+type Regexps []*regexp.Regexp
+
+// compileRegexps compiles the patterns, no patterns return nil
+func compileRegexps(patterns []string) Regexps {
+	var regexps Regexps
+	for _, pattern := range patterns {
+		regexps = append(regexps, regexp.MustCompile(pattern))
+	}
+	return regexps
+}
+
+// match returns the first pattern matching the tag
+func (r Regexps) match(tag string) (string, bool) {
+	for _, re := range r {
+		if re.MatchString(tag) {
+			return re.String(), true
+		}
+	}
+	return "", false
+}
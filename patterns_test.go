@@ -0,0 +1,26 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitPatterns(t *testing.T) {
+	tests := []struct {
+		value    string
+		patterns []string
+	}{
+		{value: "", patterns: []string{}},
+		{value: "^dev-.*", patterns: []string{"^dev-.*"}},
+		{value: "^dev-.*,^feature-.*", patterns: []string{"^dev-.*", "^feature-.*"}},
+		{value: " ^a , ,^b ", patterns: []string{"^a", "^b"}},
+		{value: "^v[0-9]{1,3}$,^x", patterns: []string{"^v[0-9]{1,3}$", "^x"}},
+		{value: "^(a,b)$,[,]", patterns: []string{"^(a,b)$", "[,]"}},
+		{value: `^a\,b$,c`, patterns: []string{`^a\,b$`, "c"}},
+	}
+	for _, test := range tests {
+		if patterns := splitPatterns(test.value); !reflect.DeepEqual(patterns, test.patterns) {
+			t.Errorf("%q: expected %q, got %q", test.value, test.patterns, patterns)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// Plan is the reviewable list of images a later apply deletes
+type Plan struct {
+	Created time.Time   `json:"created"`
+	Images  []PlanImage `json:"images"`
+}
+
+// PlanImage is an image of the plan identified by tag and digest
+type PlanImage struct {
+	Repository string    `json:"repository"`
+	Tag        string    `json:"tag"`
+	Digest     string    `json:"digest"`
+	Created    time.Time `json:"created"`
+	Size       int64     `json:"size"`
+	// Children are the platform manifests of a manifest list
+	Children []string `json:"children,omitempty"`
+}
+
+// plan writes the images which would be deleted to a plan file
+func plan(args []string) error {
+	fs := flag.NewFlagSet("plan", flag.ContinueOnError)
+	out := fs.String("out", "plan.json", "File the plan is written to")
+	parseFlags(fs, args)
+
+	// --- Collect the images to delete ---
+	skipAPIChecks()
+	images, err := collectImages()
+	if err != nil {
+		return err
+	}
+	if err := setImageDigest(images); err != nil {
+		return err
+	}
+
+	// --- Write plan ---
+	p := Plan{Created: time.Now()}
+	for _, image := range images {
+		logf(lineDeleted, "Image will be deleted: %s:%s (%s)\n", image.Name, image.Tag, image.Digest)
+		p.Images = append(p.Images, PlanImage{
+			Repository: image.Name,
+			Tag:        image.Tag,
+			Digest:     image.Digest,
+			Created:    image.Created,
+			Size:       image.Size,
+			Children:   image.Children,
+		})
+	}
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(*out, data, 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Plan with %d images written to %s\n", len(p.Images), *out)
+	return nil
+}
+
+// apply deletes exactly the images of a plan file. It refuses to delete anything
+// if a tag of the plan points to a different digest than at planning time.
+// Like pruning it only deletes with -confirm after the user agreed or with -yes.
+func apply(args []string) error {
+	fs := flag.NewFlagSet("apply", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: gitlab-registry-pruner [flags] apply plan.json")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errUsage
+	}
+
+	// Read plan
+	data, err := ioutil.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	p := Plan{}
+	if err := json.Unmarshal(data, &p); err != nil {
+		return err
+	}
+
+	// --- Verify the planned images didn't change ---
+	// The tags of the repositories are listed again, deleting needs the kept tags which share
+	// the manifest or the platforms of a planned image.
+	var images []*Image
+	tags := make(map[string]map[string]*Image)
+	changed := false
+	for _, planned := range p.Images {
+		if _, ok := tags[planned.Repository]; !ok {
+			repo := newRepository(planned.Repository)
+			if repo.Token, err = getRegistryToken(repo); err != nil {
+				return withContext(err, repo.Name, "")
+			}
+			repoImages, err := getImages(repo)
+			if err != nil {
+				return withContext(err, repo.Name, "")
+			}
+			tags[planned.Repository] = make(map[string]*Image)
+			for _, image := range repoImages {
+				tags[planned.Repository][image.Tag] = image
+			}
+		}
+
+		image, ok := tags[planned.Repository][planned.Tag]
+		var digest string
+		if ok {
+			if digest, _, err = headManifest(image.Repository, planned.Tag); err != nil {
+				return withContext(err, planned.Repository, planned.Tag)
+			}
+		}
+		if digest != planned.Digest {
+			fmt.Printf("Image %s:%s changed since planning: %s is now %q\n", planned.Repository, planned.Tag, planned.Digest, digest)
+			changed = true
+			continue
+		}
+		image.Digest = planned.Digest
+		image.Created = planned.Created
+		image.Size = planned.Size
+		image.Children = planned.Children
+		image.Result = "delete"
+		images = append(images, image)
+	}
+	if changed {
+		return errors.New("images changed since planning, create a new plan")
+	}
+
+	// --- Print planned images ---
+	for _, image := range images {
+		logf(lineDeleted, "%sImage will be deleted: %s:%s\n", dryRunPrefix(), image.Name, image.Tag)
+	}
+	printDryRunNotice()
+	if !Cfg.DeleteImages || len(images) == 0 {
+		return nil
+	}
+
+	// --- Give the user the chance to think about it ---
+	handleSignals()
+	if !Cfg.Yes {
+		if ok, err := confirmDeletion(); !ok {
+			return err
+		}
+	}
+
+	// --- Delete planned images ---
+	fmt.Println("--- Starting delete process ---")
+	if Cfg.Trash {
+		if err := trashImages(images); err != nil {
+			return err
+		}
+	}
+	return deleteImages(images)
+}
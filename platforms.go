@@ -0,0 +1,184 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Platform describes the platform a child manifest of an index is built for
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+type platformFlags []string
+
+// String returns the platform as os/arch[/variant]
+func (p *Platform) String() string {
+	if p == nil {
+		return ""
+	}
+	if p.Variant != "" {
+		return fmt.Sprintf("%s/%s/%s", p.OS, p.Architecture, p.Variant)
+	}
+	return fmt.Sprintf("%s/%s", p.OS, p.Architecture)
+}
+
+// match returns true if the platform is one of the platform flags
+func (p platformFlags) match(platform *Platform) bool {
+	for _, f := range p {
+		if platform != nil && f == platform.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// rewrittenIndex is an index of a tag without the dropped platforms
+type rewrittenIndex struct {
+	Tag       string
+	MediaType string
+	Manifest  []byte
+	Kept      []string
+	Dropped   []string
+}
+
+// dropPlatforms removes the platforms given by -drop-platform from all multi-arch tags.
+// The rewritten index is pushed to the tag and child manifests no other tag references are deleted.
+// Indexes which would lose all their platforms are kept as they are.
+func dropPlatforms(repo *Repository, images []*Image) error {
+	// --- Rewrite all indexes ---
+	var indexes []*rewrittenIndex
+	referenced := make(map[string]bool)
+	for _, image := range images {
+		manifest, mediaType, err := getRawManifest(repo.Token, repo.Name, image.Tag)
+		if err != nil {
+			return withContext(err, repo.Name, image.Tag)
+		}
+		if !isIndex(mediaType) {
+			// Single platform tags can reference a child manifest of an index
+			sum := sha256.Sum256(manifest)
+			referenced["sha256:"+hex.EncodeToString(sum[:])] = true
+			continue
+		}
+		index, err := rewriteIndex(manifest, Cfg.DropPlatforms)
+		if err != nil {
+			return withContext(fmt.Errorf("invalid index %s:%s: %w", repo.Name, image.Tag, err), repo.Name, image.Tag)
+		}
+		index.Tag = image.Tag
+		index.MediaType = mediaType
+		if len(index.Dropped) > 0 && len(index.Kept) == 0 {
+			logf(lineKept, "Image %s:%s only has dropped platforms, skipped\n", repo.Name, index.Tag)
+			index.Kept, index.Dropped = index.Dropped, nil
+		}
+		for _, digest := range index.Kept {
+			referenced[digest] = true
+		}
+		if len(index.Dropped) > 0 {
+			indexes = append(indexes, index)
+		}
+	}
+
+	// --- Print resulting platforms ---
+	for _, index := range indexes {
+		logf(lineDeleted, "%sImage %s:%s will lose %d platforms: %s\n", dryRunPrefix(), repo.Name, index.Tag,
+			len(index.Dropped), strings.Join(Cfg.DropPlatforms, ", "))
+	}
+	printDryRunNotice()
+
+	// --- Give the user the chance to think about it ---
+	if !Cfg.DeleteImages || len(indexes) == 0 {
+		return nil
+	}
+	if !Cfg.Yes {
+		if ok, err := confirmDeletion(); !ok {
+			return err
+		}
+	}
+
+	// Start delete process
+	fmt.Println("--- Starting delete process ---")
+	for _, index := range indexes {
+		// Push rewritten index first so the tag never references missing manifests
+		if err := putManifest(repo.Token, repo.Name, index.Tag, index.MediaType, index.Manifest); err != nil {
+			return withContext(err, repo.Name, index.Tag)
+		}
+		logf(lineInfo, "Image %s:%s rewritten, the tag has a new digest\n", repo.Name, index.Tag)
+
+		// Delete unreferenced child manifests
+		for _, digest := range index.Dropped {
+			if referenced[digest] {
+				continue
+			}
+			manifestURLParsed := fmt.Sprintf(manifestURL, Cfg.RegistryURL, registryPath(repo.Name), digest)
+			_, resp, err := sendHTTPRequest(manifestURLParsed, repo.Token, "DELETE", true)
+			if err != nil {
+				return withContext(err, repo.Name, index.Tag)
+			}
+			if resp.StatusCode == http.StatusAccepted || resp.StatusCode == http.StatusOK {
+				logf(lineDeleted, "Manifest deleted: %s@%s\n", repo.Name, digest)
+				deletedCount++
+			} else {
+				failedCount++
+			}
+			referenced[digest] = true
+		}
+	}
+	return nil
+}
+
+// rewriteIndex removes the child manifests of the platforms from the index.
+// Unknown fields of the index are kept as they are.
+func rewriteIndex(manifest []byte, platforms platformFlags) (*rewrittenIndex, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(manifest, &raw); err != nil {
+		return nil, err
+	}
+	var children []json.RawMessage
+	if err := json.Unmarshal(raw["manifests"], &children); err != nil {
+		return nil, err
+	}
+
+	// Filter child manifests
+	index := &rewrittenIndex{}
+	var kept []json.RawMessage
+	for _, child := range children {
+		var desc struct {
+			Digest   string    `json:"digest"`
+			Platform *Platform `json:"platform"`
+		}
+		if err := json.Unmarshal(child, &desc); err != nil {
+			return nil, err
+		}
+		if platforms.match(desc.Platform) {
+			index.Dropped = append(index.Dropped, desc.Digest)
+		} else {
+			index.Kept = append(index.Kept, desc.Digest)
+			kept = append(kept, child)
+		}
+	}
+
+	// Marshal rewritten index
+	var err error
+	if raw["manifests"], err = json.Marshal(kept); err != nil {
+		return nil, err
+	}
+	if index.Manifest, err = json.Marshal(raw); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+func (p *platformFlags) Set(value string) error {
+	*p = append(*p, value)
+	return nil
+}
+
+func (p *platformFlags) String() string {
+	return ""
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const progressBarWidth = 30
+
+// activeProgress is the progress bar currently drawn, log lines clear it first
+var activeProgress *progress
+
+// progress draws a progress bar with count and ETA on stderr if it is a terminal
+type progress struct {
+	name    string
+	total   int
+	done    int
+	start   time.Time
+	drawn   time.Time
+	enabled bool
+	sync.Mutex
+}
+
+// newProgress starts a progress bar for the given number of steps
+func newProgress(name string, total int) *progress {
+	stat, err := os.Stderr.Stat()
+	p := &progress{
+		name:    name,
+		total:   total,
+		start:   time.Now(),
+		enabled: err == nil && stat.Mode()&os.ModeCharDevice != 0 && !Cfg.Quiet && total > 0,
+	}
+	if p.enabled {
+		activeProgress = p
+	}
+	return p
+}
+
+// add marks steps as done and redraws the bar at most ten times a second
+func (p *progress) add(steps int) {
+	p.Lock()
+	defer p.Unlock()
+	p.done += steps
+	if p.enabled && (time.Since(p.drawn) > 100*time.Millisecond || p.done == p.total) {
+		p.draw()
+	}
+}
+
+// finish draws the final state and ends the line
+func (p *progress) finish() {
+	p.Lock()
+	defer p.Unlock()
+	if p.enabled {
+		p.draw()
+		fmt.Fprintln(os.Stderr)
+		activeProgress = nil
+	}
+}
+
+// clear removes the bar from the current line, the next step draws it again
+func (p *progress) clear() {
+	p.Lock()
+	defer p.Unlock()
+	fmt.Fprint(os.Stderr, "\r\033[K")
+	p.drawn = time.Time{}
+}
+
+func (p *progress) draw() {
+	p.drawn = time.Now()
+	filled := progressBarWidth * p.done / p.total
+
+	// Estimate remaining time from the average time per step
+	eta := "?"
+	if p.done > 0 {
+		elapsed := time.Since(p.start)
+		eta = (elapsed / time.Duration(p.done) * time.Duration(p.total-p.done)).Round(time.Second).String()
+	}
+	fmt.Fprintf(os.Stderr, "\r%-16s [%s%s] %d/%d ETA %s ", p.name, strings.Repeat("=", filled),
+		strings.Repeat(" ", progressBarWidth-filled), p.done, p.total, eta)
+}
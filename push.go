@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	blobUploadURL = "%s/v2/%s/blobs/uploads/"
+	blobMountURL  = "%s/v2/%s/blobs/uploads/?mount=%s&from=%s"
+)
+
+// manifestMediaTypes are all manifest types accepted when copying manifests
+var manifestMediaTypes = []string{
+	manifestV2MediaType,
+	manifestListMediaType,
+	ociManifestMediaType,
+	ociIndexMediaType,
+}
+
+// Index represents a manifest list or OCI image index
+type Index struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType"`
+	Manifests     []Descriptor `json:"manifests"`
+}
+
+// isIndex returns true if the media type is a manifest list or image index
+func isIndex(mediaType string) bool {
+	return mediaType == manifestListMediaType || mediaType == ociIndexMediaType
+}
+
+// getRawManifest returns the unmodified manifest and its media type
+func getRawManifest(token, repository, reference string) ([]byte, string, error) {
+	manifestURLParsed := fmt.Sprintf(manifestURL, Cfg.RegistryURL, registryPath(repository), reference)
+	header := map[string]string{"Accept": strings.Join(manifestMediaTypes, ", ")}
+	body, resp, err := doRegistryRequest(manifestURLParsed, token, "GET", header, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("cannot get manifest %s:%s: %w", repository, reference, newResponseError(resp, body))
+	}
+	m := &Manifest{}
+	if err := json.Unmarshal(body, m); err != nil {
+		return nil, "", err
+	}
+	return body, manifestMediaType(resp.Header.Get("Content-Type"), m), nil
+}
+
+// putManifest uploads the manifest with the given reference
+func putManifest(token, repository, reference, mediaType string, manifest []byte) error {
+	manifestURLParsed := fmt.Sprintf(manifestURL, Cfg.RegistryURL, registryPath(repository), reference)
+	header := map[string]string{"Content-Type": mediaType}
+	body, resp, err := doRegistryRequest(manifestURLParsed, token, "PUT", header, strings.NewReader(string(manifest)))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("cannot put manifest %s:%s: %w", repository, reference, newResponseError(resp, body))
+	}
+	return nil
+}
+
+// copyManifest copies a manifest with all referenced blobs and child manifests between
+// repositories of the registry
+func copyManifest(token, srcRepository, srcReference, dstRepository, dstReference string) error {
+	manifest, mediaType, err := getRawManifest(token, srcRepository, srcReference)
+	if err != nil {
+		return err
+	}
+
+	if isIndex(mediaType) {
+		// Copy child manifests by digest first
+		index := &Index{}
+		if err := json.Unmarshal(manifest, index); err != nil {
+			return err
+		}
+		for _, child := range index.Manifests {
+			if err := copyManifest(token, srcRepository, child.Digest, dstRepository, child.Digest); err != nil {
+				return err
+			}
+		}
+	} else {
+		// Mount config and layers
+		m := &Manifest{}
+		if err := json.Unmarshal(manifest, m); err != nil {
+			return err
+		}
+		for _, blob := range append([]Descriptor{m.Config}, m.Layers...) {
+			if !blob.foreign() {
+				if err := mountBlob(token, srcRepository, dstRepository, blob.Digest); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return putManifest(token, dstRepository, dstReference, mediaType, manifest)
+}
+
+// mountBlob makes the blob of the source repository available in the destination repository.
+// The blob is copied if the registry cannot mount it.
+func mountBlob(token, srcRepository, dstRepository, digest string) error {
+	mountURL := fmt.Sprintf(blobMountURL, Cfg.RegistryURL, registryPath(dstRepository),
+		url.QueryEscape(digest), url.QueryEscape(repositoryName(srcRepository)))
+	body, resp, err := doRegistryRequest(mountURL, token, "POST", nil, nil)
+	if err != nil {
+		return err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusCreated:
+		// Blob mounted
+		return nil
+	case http.StatusAccepted:
+		// Registry started a regular upload instead, copy the blob
+		blobURLParsed := fmt.Sprintf(blobURL, Cfg.RegistryURL, registryPath(srcRepository), digest)
+		req, err := http.NewRequest("GET", blobURLParsed, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+		if Cfg.BandwidthLimit > 0 {
+			// The blob is read at the pace of the throttled upload
+			req = throttled(req)
+		}
+		blobResp, err := httpClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer blobResp.Body.Close()
+		if blobResp.StatusCode != http.StatusOK {
+			return fmt.Errorf("cannot get blob %s: %w", digest, newResponseError(blobResp, nil))
+		}
+		return finishUpload(token, resp.Header.Get("Location"), digest, blobResp.Body, blobResp.ContentLength)
+	default:
+		return fmt.Errorf("cannot mount blob %s: %w", digest, newResponseError(resp, body))
+	}
+}
+
+// uploadBlobFile uploads a local blob file unless the repository already has it
+func uploadBlobFile(token, repository, digest, path string) error {
+	// Check if blob already exists
+	blobURLParsed := fmt.Sprintf(blobURL, Cfg.RegistryURL, registryPath(repository), digest)
+	_, resp, err := doRegistryRequest(blobURLParsed, token, "HEAD", nil, nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	// Start upload
+	uploadURL := fmt.Sprintf(blobUploadURL, Cfg.RegistryURL, registryPath(repository))
+	body, resp, err := doRegistryRequest(uploadURL, token, "POST", nil, nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("cannot upload blob %s: %w", digest, newResponseError(resp, body))
+	}
+
+	// Upload file in one piece
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return finishUpload(token, resp.Header.Get("Location"), digest, f, stat.Size())
+}
+
+// finishUpload sends the blob content to the upload location and completes the upload
+func finishUpload(token, location, digest string, data io.Reader, size int64) error {
+	// Copies must not saturate the registry's uplink
+	if Cfg.BandwidthLimit > 0 {
+		data = &throttledReader{data}
+	}
+
+	// Location can be relative to the registry
+	uploadURL, err := url.Parse(Cfg.RegistryURL)
+	if err != nil {
+		return err
+	}
+	if uploadURL, err = uploadURL.Parse(location); err != nil {
+		return err
+	}
+	query := uploadURL.Query()
+	query.Set("digest", digest)
+	uploadURL.RawQuery = query.Encode()
+
+	req, err := http.NewRequest("PUT", uploadURL.String(), data)
+	if err != nil {
+		return err
+	}
+	if Cfg.BandwidthLimit > 0 {
+		req = throttled(req)
+	}
+	req.ContentLength = size
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("cannot upload blob %s: %w", digest, newResponseError(resp, nil))
+	}
+	return nil
+}
+
+// blobPath returns the path of a blob in an OCI image layout
+func blobPath(layout, digest string) string {
+	return filepath.Join(layout, "blobs", strings.Replace(digest, ":", string(filepath.Separator), 1))
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const referrersURL = "%s/v2/%s/referrers/%s"
+
+// getReferrers returns the artifacts (signatures, SBOMs, attestations) attached to the manifest and,
+// recursively, to those artifacts. Besides the OCI referrers API, tags following the tag schema
+// (sha256-<hex>, sha256-<hex>.sig, .att, .sbom) are used, which cosign and older registries rely on.
+func getReferrers(repo *Repository, digest string, seen map[string]bool) ([]Descriptor, error) {
+	if digest == "" {
+		return nil, nil
+	}
+	if seen == nil {
+		seen = make(map[string]bool)
+	}
+
+	// Ask the referrers API
+	referrers, err := queryReferrers(repo, digest)
+	if err != nil {
+		return nil, err
+	}
+
+	// Add the tags of the tag schema unless they are kept
+	if repo.signatures() == "keep" {
+		return filterReferrers(repo, referrers, seen)
+	}
+	tag := strings.Replace(digest, ":", "-", 1)
+	if err := resolveDigests(tagSchemaImages(repo, tag)); err != nil {
+		return nil, err
+	}
+	for _, image := range tagSchemaImages(repo, tag) {
+		referrers = append(referrers, Descriptor{MediaType: image.MediaType, ArtifactType: image.artifactType(), Digest: image.Digest})
+	}
+
+	return filterReferrers(repo, referrers, seen)
+}
+
+// filterReferrers removes already seen referrers and adds the referrers of the referrers
+func filterReferrers(repo *Repository, referrers []Descriptor, seen map[string]bool) ([]Descriptor, error) {
+	var result []Descriptor
+	for _, referrer := range referrers {
+		if seen[referrer.Digest] || referrer.Digest == "" {
+			continue
+		}
+		seen[referrer.Digest] = true
+		result = append(result, referrer)
+		nested, err := getReferrers(repo, referrer.Digest, seen)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, nested...)
+	}
+	return result, nil
+}
+
+// queryReferrers returns the manifests the OCI referrers API lists for the digest,
+// nothing if the registry doesn't support it
+func queryReferrers(repo *Repository, digest string) ([]Descriptor, error) {
+	referrersURLParsed := fmt.Sprintf(referrersURL, Cfg.RegistryURL, registryPath(repo.Name), digest)
+	body, resp, err := doRegistryRequest(referrersURLParsed, repo.Token, "GET", map[string]string{"Accept": ociIndexMediaType}, nil)
+	if err != nil {
+		return nil, err
+	}
+	index := &Manifest{}
+	if resp.StatusCode != http.StatusOK || json.Unmarshal(body, index) != nil {
+		return nil, nil
+	}
+	return index.Manifests, nil
+}
+
+// tagSchemaImages returns the scanned images of the repository with the tag schema tag of a digest
+func tagSchemaImages(repo *Repository, tag string) []*Image {
+	var images []*Image
+	for _, image := range repo.Images {
+		if image.Tag == tag || strings.HasPrefix(image.Tag, tag+".") {
+			images = append(images, image)
+		}
+	}
+	return images
+}
+
+// deleteReferrers deletes the artifacts attached to the deleted image
+func deleteReferrers(image *Image, referrers []Descriptor) error {
+	for _, referrer := range referrers {
+		manifestURLParsed := fmt.Sprintf(manifestURL, Cfg.RegistryURL, registryPath(image.Name), referrer.Digest)
+		_, resp, err := sendHTTPRequest(manifestURLParsed, image.Repository.Token, "DELETE", true)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
+			continue
+		}
+		logf(lineDeleted, "Referrer of %s:%s deleted: %s %s\n", image.Name, image.Tag, referrer.Digest, referrer.ArtifactType)
+
+		// Tags of the tag schema are gone with the manifest
+		for _, other := range image.Repository.Images {
+			if other.Digest == referrer.Digest && other.Result == "" {
+				other.Result = "deleted"
+				other.Reason = fmt.Sprintf("attached to %s:%s", image.Name, image.Tag)
+			}
+		}
+		err = audit(AuditEntry{
+			Event:      "delete",
+			Repository: image.Name,
+			Digest:     referrer.Digest,
+			Decision:   "deleted",
+			Reason:     fmt.Sprintf("attached to %s:%s", image.Name, image.Tag),
+			Status:     resp.StatusCode,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
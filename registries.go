@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// RegistryConfig is an item of the registries list of the config file. All registries are pruned
+// one after the other with the same policies, settings which are missing are taken from the flags.
+type RegistryConfig struct {
+	Name            string   `yaml:"name"`
+	RegistryURL     string   `yaml:"registryurl"`
+	GitlabURL       string   `yaml:"giturl"`
+	Username        string   `yaml:"user"`
+	Password        string   `yaml:"password"`
+	Token           string   `yaml:"token"`
+	DeployTokenUser string   `yaml:"deploy-token-user"`
+	DeployToken     string   `yaml:"deploy-token"`
+	Repositories    []string `yaml:"repositories"`
+}
+
+// unsafeFileChars are replaced in registry names used in file names
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// pruneAll prunes the registries of the config file or the registry of the flags
+func pruneAll() error {
+	if len(Cfg.Registries) > 0 {
+		return pruneRegistries()
+	}
+	return pruneRegistry()
+}
+
+// pruneRegistry prunes the registry of the current configuration
+func pruneRegistry() error {
+	skipAPIChecks()
+	if Cfg.Stream {
+		return pruneStream()
+	}
+	return prune()
+}
+
+// pruneRegistries prunes the registries of the config file one after the other. A failed registry
+// doesn't stop the others unless -fail-fast is given.
+func pruneRegistries() error {
+	base := *Cfg
+	defer func() {
+		*Cfg = base
+	}()
+	for i, registry := range base.Registries {
+		if isInterrupted() {
+			fmt.Printf("Interrupted, %d registries skipped\n", len(base.Registries)-i)
+			break
+		}
+		fmt.Printf("--- Registry %s ---\n", registry.Name)
+
+		// Every registry is a run of its own with the settings of the registry
+		*Cfg = base
+		if err := registry.apply(); err != nil {
+			return err
+		}
+		resetRun()
+		if _, err := tolerate("prune registry", registry.Name, "", pruneRegistry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply sets the endpoints, credentials, repositories and output files of the registry
+func (r *RegistryConfig) apply() error {
+	Cfg.RegistryURL = r.RegistryURL
+	if r.GitlabURL != "" {
+		Cfg.GitlabURL = r.GitlabURL
+	}
+
+	// Credentials of the registry replace the ones of the flags
+	if r.Username != "" || r.Password != "" || r.Token != "" || r.DeployToken != "" {
+		Cfg.Username, Cfg.Password, Cfg.Token, Cfg.JobToken = r.Username, r.Password, r.Token, ""
+		Cfg.DeployTokenUser, Cfg.DeployToken = r.DeployTokenUser, r.DeployToken
+	} else if Cfg.UseDockerConfig {
+		if err := useDockerConfig(); err != nil {
+			return err
+		}
+	}
+	if len(r.Repositories) > 0 {
+		Cfg.Repositories = append(repositoryFlags{}, r.Repositories...)
+	}
+
+	// Files of the run get the registry name so the registries don't overwrite each other
+	Cfg.OutputFile = registryFile(Cfg.OutputFile, r.Name)
+	Cfg.ReportFile = registryFile(Cfg.ReportFile, r.Name)
+	Cfg.ReportHTML = registryFile(Cfg.ReportHTML, r.Name)
+	Cfg.Checkpoint = registryFile(Cfg.Checkpoint, r.Name)
+	Cfg.TombstoneFile = registryFile(Cfg.TombstoneFile, r.Name)
+	return checkEndpoints()
+}
+
+// registryFile inserts the registry name before the extension of the file, - stays stdout
+func registryFile(file, name string) string {
+	if file == "" || file == "-" {
+		return file
+	}
+	ext := filepath.Ext(file)
+	return strings.TrimSuffix(file, ext) + "-" + unsafeFileChars.ReplaceAllString(name, "-") + ext
+}
+
+// resetRun forgets the repositories, clusters and timings of the previous run.
+// The counts for the exit code are kept.
+func resetRun() {
+	scannedRepositories = nil
+	clusters = nil
+	phaseLock.Lock()
+	phaseDurations = make(map[string]time.Duration)
+	phaseLock.Unlock()
+	startTime = time.Now()
+	errorContext.Lock()
+	errorContext.Stack = nil
+	errorContext.Unlock()
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Repositories whose tags were listed during this run
+var scannedRepositories []*Repository
+
+// Formats supported by -output and -report
+var (
+	outputFormats = map[string]bool{"csv": true}
+	reportFormats = map[string]bool{"markdown": true}
+)
+
+// Reasons of kept images which are listed in reports
+var protectedReasons = []string{"well-known tag", "protected by GitLab", "in protect file", "matches keep regexp", "used in cluster", "running pipeline", "signed by", "digest shared with kept tag"}
+
+// decision returns what happened to the image in this run
+func (image *Image) decision() string {
+	if image.Result != "" {
+		return image.Result
+	}
+	return "keep"
+}
+
+// stdout is closed by the runtime, not by writers
+type stdout struct{ io.Writer }
+
+func (stdout) Close() error { return nil }
+
+// createFile opens the file for writing, - is stdout
+func createFile(file string) (io.WriteCloser, error) {
+	if file == "-" {
+		return stdout{os.Stdout}, nil
+	}
+	return os.Create(file)
+}
+
+// protected returns true if the image was kept for a reason listed in reports
+func (image *Image) protected() bool {
+	for _, reason := range protectedReasons {
+		if strings.HasPrefix(image.Reason, reason) {
+			return true
+		}
+	}
+	return false
+}
+
+// removed returns true if the image was deleted or would have been deleted by a dry run
+func (image *Image) removed() bool {
+	d := image.decision()
+	return d == "deleted" || (!Cfg.DeleteImages && d == "delete")
+}
+
+// writeOutput writes the decision for every scanned tag in the -output format
+func writeOutput() error {
+	out, err := openOutput()
+	if err != nil {
+		return err
+	}
+	for _, repo := range scannedRepositories {
+		out.write(repo)
+	}
+	return out.close()
+}
+
+// writeCSV writes one row per scanned tag
+func writeCSV(w io.Writer) error {
+	out := newOutputWriter(w)
+	for _, repo := range scannedRepositories {
+		out.write(repo)
+	}
+	return out.close()
+}
+
+// outputWriter writes the decisions as CSV. Streamed runs write every repository once it is decided.
+type outputWriter struct {
+	file io.Closer
+	csv  *csv.Writer
+}
+
+// newOutputWriter writes the header to w
+func newOutputWriter(w io.Writer) *outputWriter {
+	out := &outputWriter{csv: csv.NewWriter(w)}
+	out.csv.Write([]string{"repository", "tag", "digest", "created", "size", "decision", "reason"})
+	return out
+}
+
+// openOutput creates the -output file
+func openOutput() (*outputWriter, error) {
+	if Cfg.Output != "csv" {
+		return nil, fmt.Errorf("unknown output format %q", Cfg.Output)
+	}
+	f, err := createFile(Cfg.OutputFile)
+	if err != nil {
+		return nil, err
+	}
+	out := newOutputWriter(f)
+	out.file = f
+	return out, nil
+}
+
+// write writes one row per tag of the repository
+func (o *outputWriter) write(repo *Repository) {
+	for _, image := range repo.Images {
+		var created string
+		if !image.Created.IsZero() {
+			created = image.Created.Format(time.RFC3339)
+		}
+		o.csv.Write([]string{
+			image.Name,
+			image.Tag,
+			image.Digest,
+			created,
+			strconv.FormatInt(image.Size, 10),
+			image.decision(),
+			image.Reason,
+		})
+	}
+}
+
+// close flushes the rows and closes the file
+func (o *outputWriter) close() error {
+	o.csv.Flush()
+	err := o.csv.Error()
+	if o.file != nil {
+		err = firstError(err, o.file.Close())
+	}
+	return err
+}
+
+// writeReport writes a summary of the run in the -report format
+func writeReport() error {
+	w, err := createFile(Cfg.ReportFile)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	switch Cfg.Report {
+	case "markdown":
+		writeMarkdownReport(w)
+	default:
+		return fmt.Errorf("unknown report format %q", Cfg.Report)
+	}
+	return nil
+}
+
+// writeMarkdownReport writes a summary table in GitLab flavored markdown
+func writeMarkdownReport(w io.Writer) {
+	// Tags still marked delete after a real run were planned but not deleted, e.g. declined with -confirm
+	title, columns, align := "Registry prune report", "Deleted | Planned", "---:|---:"
+	if !Cfg.DeleteImages {
+		title, columns, align = "Registry prune report (dry run)", "To delete", "---:"
+	}
+	fmt.Fprintf(w, "## %s\n\n", title)
+	fmt.Fprintf(w, "| Repository | Tags | Kept | %s | Freed |\n", columns)
+	fmt.Fprintf(w, "|---|---:|---:|%s|---:|\n", align)
+
+	// Summary per repository
+	var totalTags, totalKept, totalPlanned int
+	var protected, totalDeleted []*Image
+	for _, repo := range scannedRepositories {
+		var deleted []*Image
+		var planned int
+		for _, image := range repo.Images {
+			if image.removed() {
+				deleted = append(deleted, image)
+			} else if image.decision() == "delete" {
+				planned++
+			}
+			if image.protected() {
+				protected = append(protected, image)
+			}
+		}
+		kept := repo.TagCount - len(deleted)
+		freed, _ := reclaimableBytes(deleted)
+		counts := fmt.Sprintf("%d", len(deleted))
+		if Cfg.DeleteImages {
+			counts += fmt.Sprintf(" | %d", planned)
+		}
+		fmt.Fprintf(w, "| `%s` | %d | %d | %s | %s |\n", repo.Name, repo.TagCount, kept, counts, formatBytes(freed))
+		totalTags += repo.TagCount
+		totalKept += kept
+		totalPlanned += planned
+		totalDeleted = append(totalDeleted, deleted...)
+	}
+	totalFreed, _ := reclaimableBytes(totalDeleted)
+	totals := fmt.Sprintf("**%d**", len(totalDeleted))
+	if Cfg.DeleteImages {
+		totals += fmt.Sprintf(" | **%d**", totalPlanned)
+	}
+	fmt.Fprintf(w, "| **Total** | **%d** | **%d** | %s | **%s** |\n", totalTags, totalKept, totals, formatBytes(totalFreed))
+
+	// Notable protected tags
+	if len(protected) > 0 {
+		fmt.Fprintf(w, "\n<details><summary>%d protected tags</summary>\n\n", len(protected))
+		for _, image := range protected {
+			fmt.Fprintf(w, "- `%s:%s` %s\n", image.Name, image.Tag, image.Reason)
+		}
+		fmt.Fprintln(w, "\n</details>")
+	}
+}
+
+// formatBytes returns the size in human readable binary units
+func formatBytes(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+const (
+	groupRepositoriesURL = "%s/api/v4/groups/%s/registry/repositories?per_page=100&page=%s"
+	projectURL           = "%s/api/v4/projects/%s"
+)
+
+// repositoryName returns the normalized repository name. The registry only knows lowercase paths.
+func repositoryName(repository string) string {
+	return strings.ToLower(strings.Trim(repository, "/"))
+}
+
+// registryPath returns the escaped repository path used in registry URLs.
+// Slashes are kept since the registry routes on the path segments.
+func registryPath(repository string) string {
+	segments := strings.Split(repositoryName(repository), "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// Repository represents a repository in the registry
+type Repository struct {
+	Name     string
+	Path     string
+	Token    string
+	TagCount int
+	Images   []*Image
+	Policy   *RepositoryPolicy
+	// Released is set once a streamed run released the blobs and the kept images
+	Released bool
+	// ProjectID is the escaped path of the owning project once projectID looked it up
+	ProjectID string
+}
+
+type repositoryFlags []string
+
+// projectID returns the escaped project id or path used in GitLab API URLs
+func projectID(repo *Repository) (string, error) {
+	if Cfg.ProjectID != "" {
+		return url.PathEscape(Cfg.ProjectID), nil
+	}
+	if repo.ProjectID == "" {
+		id, err := findProject(repo.Path)
+		if err != nil {
+			return "", err
+		}
+		repo.ProjectID = id
+	}
+	return repo.ProjectID, nil
+}
+
+// findProject returns the escaped path of the project owning the repository. Repositories can be
+// nested below their project (group/project/image), so the path is shortened until GitLab knows it.
+func findProject(repoPath string) (string, error) {
+	segments := strings.Split(strings.Trim(repoPath, "/"), "/")
+	for n := len(segments); n >= 2; n-- {
+		id := url.PathEscape(strings.Join(segments[:n], "/"))
+		body, resp, err := sendGitlabRequest(fmt.Sprintf(projectURL, Cfg.GitlabURL, id), "GET", nil)
+		if err != nil {
+			return "", fmt.Errorf("cannot find the project of %s: %w", repoPath, err)
+		}
+		switch resp.StatusCode {
+		case http.StatusOK:
+			return id, nil
+		case http.StatusNotFound:
+			continue
+		default:
+			return "", fmt.Errorf("cannot find the project of %s: %w", repoPath, newResponseError(resp, body))
+		}
+	}
+	return "", fmt.Errorf("no GitLab project owns the repository %s", repoPath)
+}
+
+// newRepository creates the repository with the first matching repository policy
+func newRepository(repoPath string) *Repository {
+	repo := &Repository{Name: repositoryName(repoPath), Path: repoPath, Policy: &RepositoryPolicy{}}
+	for _, policy := range Cfg.RepositoryPolicies {
+		if ok, _ := path.Match(repositoryName(policy.Path), repo.Name); ok {
+			repo.Policy = policy
+			break
+		}
+	}
+	return repo
+}
+
+// minExpiry returns the minimum age in days of images which shall be removed
+func (r *Repository) minExpiry() int {
+	if r.Policy.MinExpiry != nil {
+		return *r.Policy.MinExpiry
+	}
+	return Cfg.MinExpiry
+}
+
+// keepRegexps returns the regex patterns of tags which are never deleted
+func (r *Repository) keepRegexps() Regexps {
+	switch {
+	case r.Policy.KeepRegexPatterns != nil:
+		return compileRegexps(r.Policy.KeepRegexPatterns)
+	case r.Policy.RegexPatterns != nil:
+		return compileRegexps(r.Policy.RegexPatterns)
+	}
+	return compileRegexps(Cfg.RegexPatterns)
+}
+
+// deleteRegexps returns the regex patterns of which tags must match one to be deleted
+func (r *Repository) deleteRegexps() Regexps {
+	if r.Policy.DeleteRegexPatterns != nil {
+		return compileRegexps(r.Policy.DeleteRegexPatterns)
+	}
+	return compileRegexps(Cfg.DeleteRegexPatterns)
+}
+
+// keepLatest returns the number of newest images which are always kept
+func (r *Repository) keepLatest() int {
+	if r.Policy.KeepLatest != nil {
+		return *r.Policy.KeepLatest
+	}
+	return Cfg.KeepLatest
+}
+
+// signatures returns how signature tags are handled
+func (r *Repository) signatures() string {
+	if r.Policy.Signatures != "" {
+		return r.Policy.Signatures
+	}
+	return Cfg.Signatures
+}
+
+func (r *repositoryFlags) Set(value string) error {
+	*r = append(*r, value)
+	return nil
+}
+
+func (r *repositoryFlags) String() string {
+	return ""
+}
+
+// expandRepositories replaces wildcard patterns (e.g. mygroup/*/backend) by the matching repositories.
+// Patterns are expanded against the registry repositories of the group the pattern starts with,
+// or against the registry catalog if it starts with a wildcard.
+func expandRepositories(patterns []string) ([]string, error) {
+	var repositories []string
+	for _, pattern := range patterns {
+		if !strings.ContainsAny(pattern, "*?[") {
+			repositories = append(repositories, pattern)
+			continue
+		}
+
+		// Get literal group prefix
+		var group []string
+		for _, segment := range strings.Split(strings.Trim(pattern, "/"), "/") {
+			if strings.ContainsAny(segment, "*?[") {
+				break
+			}
+			group = append(group, segment)
+		}
+
+		// Get candidates
+		var candidates []string
+		var err error
+		if len(group) == 0 {
+			candidates, err = getCatalog()
+		} else {
+			candidates, err = getGroupRepositories(strings.Join(group, "/"))
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// Match candidates, wildcards don't match slashes
+		matched := 0
+		for _, candidate := range candidates {
+			if ok, err := path.Match(repositoryName(pattern), repositoryName(candidate)); err != nil {
+				return nil, err
+			} else if ok {
+				repositories = append(repositories, candidate)
+				matched++
+			}
+		}
+		fmt.Printf("Repository pattern %s matches %d repositories\n", pattern, matched)
+	}
+	return repositories, nil
+}
+
+// getGroupRepositories returns the paths of all registry repositories of the group and its subgroups
+func getGroupRepositories(group string) ([]string, error) {
+	var repositories []string
+	page := "1"
+	for page != "" {
+		// Create request
+		body, resp, err := sendGitlabRequest(fmt.Sprintf(groupRepositoriesURL, Cfg.GitlabURL, url.PathEscape(group), page), "GET", nil)
+		if err != nil {
+			return nil, err
+		}
+
+		// Validate response
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("cannot list repositories of group %s: %w", group, newResponseError(resp, body))
+		}
+
+		// Extract repositories from response
+		var data []map[string]interface{}
+		if err := json.Unmarshal(body, &data); err != nil {
+			return nil, fmt.Errorf("invalid repositories of group %s: %w", group, err)
+		}
+		for _, repository := range data {
+			if p, ok := repository["path"].(string); ok {
+				repositories = append(repositories, p)
+			}
+		}
+		page = resp.Header.Get("X-Next-Page")
+	}
+	return repositories, nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	ociLayoutPrefix   = "oci:"
+	refNameAnnotation = "org.opencontainers.image.ref.name"
+)
+
+// restore re-pushes an image from an archive repository or an OCI image layout to its original tag
+func restore(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	from := fs.String("from", "", "Repository (e.g. group/project/trash) or OCI image layout (oci:/path/to/layout) to restore from")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: gitlab-registry-pruner [flags] restore -from <repository|oci:dir> repository:tag")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+
+	// Validate arguments
+	i := strings.LastIndex(fs.Arg(0), ":")
+	if *from == "" || fs.NArg() != 1 || i < 1 {
+		fs.Usage()
+		return errUsage
+	}
+	repository, tag := fs.Arg(0)[:i], fs.Arg(0)[i+1:]
+
+	if strings.HasPrefix(*from, ociLayoutPrefix) {
+		// Push image from the OCI image layout
+		token, err := getRegistryTokenForScopes(fmt.Sprintf("repository:%s:pull,push", repositoryName(repository)))
+		if err != nil {
+			return err
+		}
+		if err := restoreFromLayout(token, strings.TrimPrefix(*from, ociLayoutPrefix), repository, tag); err != nil {
+			return err
+		}
+	} else {
+		// Copy image from the archive repository
+		token, err := getRegistryTokenForScopes(
+			fmt.Sprintf("repository:%s:pull", repositoryName(*from)),
+			fmt.Sprintf("repository:%s:pull,push", repositoryName(repository)))
+		if err != nil {
+			return err
+		}
+		if err := copyManifest(token, *from, tag, repository, untrashedTag(tag)); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Image restored: %s:%s\n", repositoryName(repository), untrashedTag(tag))
+	return nil
+}
+
+// restoreFromLayout pushes the image with the given tag from the OCI image layout
+func restoreFromLayout(token, layout, repository, tag string) error {
+	// Read layout index
+	data, err := ioutil.ReadFile(filepath.Join(layout, "index.json"))
+	if err != nil {
+		return err
+	}
+	index := &Index{}
+	if err := json.Unmarshal(data, index); err != nil {
+		return err
+	}
+
+	// Find manifest by tag
+	for _, desc := range index.Manifests {
+		ref := desc.Annotations[refNameAnnotation]
+		if ref == tag || ref == repositoryName(repository)+":"+tag {
+			return pushLayoutManifest(token, layout, repository, desc.Digest, tag, desc.MediaType)
+		}
+	}
+	return fmt.Errorf("tag %s not found in %s", tag, layout)
+}
+
+// pushLayoutManifest pushes a manifest with all referenced blobs and child manifests from the OCI image layout
+func pushLayoutManifest(token, layout, repository, digest, reference, mediaType string) error {
+	manifest, err := ioutil.ReadFile(blobPath(layout, digest))
+	if err != nil {
+		return err
+	}
+
+	if isIndex(mediaType) {
+		// Push child manifests by digest first
+		index := &Index{}
+		if err := json.Unmarshal(manifest, index); err != nil {
+			return err
+		}
+		for _, child := range index.Manifests {
+			if err := pushLayoutManifest(token, layout, repository, child.Digest, child.Digest, child.MediaType); err != nil {
+				return err
+			}
+		}
+	} else {
+		// Upload config and layers
+		m := &Manifest{}
+		if err := json.Unmarshal(manifest, m); err != nil {
+			return err
+		}
+		for _, blob := range append([]Descriptor{m.Config}, m.Layers...) {
+			if !blob.foreign() {
+				if err := uploadBlobFile(token, repository, blob.Digest, blobPath(layout, blob.Digest)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return putManifest(token, repository, reference, mediaType, manifest)
+}
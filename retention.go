@@ -0,0 +1,245 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// retentionTier keeps the newest image per period for the last Count periods
+type retentionTier struct {
+	Period string
+	Count  int
+}
+
+type retentionTiers []retentionTier
+
+// Set parses tiers in the form period=count (e.g. day=14,week=13,month=12)
+func (r *retentionTiers) Set(value string) error {
+	for _, part := range strings.Split(value, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid retention tier %q, expected period=count", part)
+		}
+
+		// Validate period
+		switch kv[0] {
+		case "day", "week", "month", "year":
+		default:
+			return fmt.Errorf("invalid retention period %q, expected day, week, month or year", kv[0])
+		}
+
+		// Validate count
+		count, err := strconv.Atoi(kv[1])
+		if err != nil || count < 1 {
+			return fmt.Errorf("invalid retention count %q", kv[1])
+		}
+		*r = append(*r, retentionTier{Period: kv[0], Count: count})
+	}
+	return nil
+}
+
+func (r *retentionTiers) String() string {
+	return ""
+}
+
+// bucket returns the period bucket key for the given time
+func (t retentionTier) bucket(created time.Time) string {
+	switch t.Period {
+	case "day":
+		return created.Format("2006-01-02")
+	case "week":
+		year, week := created.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	case "month":
+		return created.Format("2006-01")
+	default:
+		return created.Format("2006")
+	}
+}
+
+// windowStart returns the earliest time covered by this tier
+func (t retentionTier) windowStart(now time.Time) time.Time {
+	switch t.Period {
+	case "day":
+		return now.AddDate(0, 0, -t.Count)
+	case "week":
+		return now.AddDate(0, 0, -7*t.Count)
+	case "month":
+		return now.AddDate(0, -t.Count, 0)
+	default:
+		return now.AddDate(-t.Count, 0, 0)
+	}
+}
+
+// filterRetentionTiers removes the newest image per period of every tier from the slice
+func filterRetentionTiers(images []*Image, tiers retentionTiers) []*Image {
+	now := time.Now()
+	keep := make(map[*Image]string)
+
+	for _, tier := range tiers {
+		// Find the newest image per bucket inside the tier window
+		start := tier.windowStart(now)
+		newest := make(map[string]*Image)
+		for _, image := range images {
+			if image.Created.Before(start) {
+				continue
+			}
+			key := tier.bucket(image.Created)
+			if n, ok := newest[key]; !ok || image.Created.After(n.Created) {
+				newest[key] = image
+			}
+		}
+
+		// Remember the images to keep
+		for key, image := range newest {
+			if _, ok := keep[image]; !ok {
+				keep[image] = fmt.Sprintf("%s %s", tier.Period, key)
+			}
+		}
+	}
+
+	// Remove kept images
+	i := 0
+	for _, image := range images {
+		if reason, ok := keep[image]; ok {
+			// Print information
+			logf(lineKept, "Image %s:%s is the newest of %s, skipped: %s\n", image.Name, image.Tag, reason, image.Created.String())
+			image.Reason = "newest of " + reason
+		} else {
+			// This image should stay in slice
+			images[i] = image
+			i++
+		}
+	}
+	return images[:i]
+}
+
+// tagPolicy sets the expiry in days for tags matching the pattern (-1 keeps forever)
+type tagPolicy struct {
+	Pattern *regexp.Regexp
+	Days    int
+	Matched int
+	Expired int
+}
+
+type tagPolicies []*tagPolicy
+
+// Set parses a policy in the form pattern=days or pattern=forever
+func (p *tagPolicies) Set(value string) error {
+	i := strings.LastIndex(value, "=")
+	if i < 1 {
+		return fmt.Errorf("invalid tag policy %q, expected pattern=days", value)
+	}
+
+	// Parse expiry
+	days := -1
+	if value[i+1:] != "forever" {
+		var err error
+		if days, err = strconv.Atoi(value[i+1:]); err != nil || days < 0 {
+			return fmt.Errorf("invalid tag policy expiry %q", value[i+1:])
+		}
+	}
+
+	// Compile pattern
+	re, err := regexp.Compile(value[:i])
+	if err != nil {
+		return err
+	}
+	*p = append(*p, &tagPolicy{Pattern: re, Days: days})
+	return nil
+}
+
+func (p *tagPolicies) String() string {
+	return ""
+}
+
+// match returns the first policy matching the tag or nil
+func (p tagPolicies) match(tag string) *tagPolicy {
+	for _, policy := range p {
+		if policy.Pattern.MatchString(tag) {
+			return policy
+		}
+	}
+	return nil
+}
+
+// expiry returns the policy expiry as readable string
+func (t *tagPolicy) expiry() string {
+	if t.Days < 0 {
+		return "forever"
+	}
+	return fmt.Sprintf("%d days", t.Days)
+}
+
+// filterKeepPercent removes the newest percent of images from the slice
+func filterKeepPercent(images []*Image, percent int) []*Image {
+	// Sort by created date, newest first
+	sorted := make([]*Image, len(images))
+	copy(sorted, images)
+	sort.Slice(sorted, func(a, b int) bool {
+		return sorted[a].Created.After(sorted[b].Created)
+	})
+
+	// Remember the images to keep, rounded up
+	keep := make(map[*Image]bool)
+	count := (len(sorted)*percent + 99) / 100
+	if count > len(sorted) {
+		count = len(sorted)
+	}
+	for _, image := range sorted[:count] {
+		keep[image] = true
+	}
+
+	// Remove kept images
+	i := 0
+	for _, image := range images {
+		if keep[image] {
+			// Print information
+			logf(lineKept, "Image %s:%s is within the newest %d%%, skipped: %s\n", image.Name, image.Tag, percent, image.Created.String())
+			image.Reason = fmt.Sprintf("within newest %d%%", percent)
+		} else {
+			// This image should stay in slice
+			images[i] = image
+			i++
+		}
+	}
+	return images[:i]
+}
+
+// filterKeepLatest removes the newest count images of all images from the slice
+func filterKeepLatest(images []*Image, all []*Image, count int) []*Image {
+	// Sort by created date, newest first
+	sorted := make([]*Image, len(all))
+	copy(sorted, all)
+	sort.Slice(sorted, func(a, b int) bool {
+		return sorted[a].Created.After(sorted[b].Created)
+	})
+
+	// Remember the images to keep
+	keep := make(map[*Image]bool)
+	if count > len(sorted) {
+		count = len(sorted)
+	}
+	for _, image := range sorted[:count] {
+		keep[image] = true
+	}
+
+	// Remove kept images
+	i := 0
+	for _, image := range images {
+		if keep[image] {
+			// Print information
+			logf(lineKept, "Image %s:%s is one of the %d newest, skipped: %s\n", image.Name, image.Tag, count, image.Created.String())
+			image.Reason = fmt.Sprintf("one of %d newest", count)
+		} else {
+			// This image should stay in slice
+			images[i] = image
+			i++
+		}
+	}
+	return images[:i]
+}
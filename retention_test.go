@@ -0,0 +1,139 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetentionTiersSet(t *testing.T) {
+	tests := []struct {
+		value string
+		tiers retentionTiers
+		err   bool
+	}{
+		{value: "day=14", tiers: retentionTiers{{"day", 14}}},
+		{value: "day=14, week=13,month=12,year=2", tiers: retentionTiers{{"day", 14}, {"week", 13}, {"month", 12}, {"year", 2}}},
+		{value: "day", err: true},
+		{value: "hour=2", err: true},
+		{value: "week=0", err: true},
+		{value: "month=x", err: true},
+	}
+	for _, test := range tests {
+		var tiers retentionTiers
+		err := tiers.Set(test.value)
+		if (err != nil) != test.err {
+			t.Errorf("%s: expected error %v, got %v", test.value, test.err, err)
+			continue
+		}
+		if !test.err && !sameTiers(tiers, test.tiers) {
+			t.Errorf("%s: expected %v, got %v", test.value, test.tiers, tiers)
+		}
+	}
+}
+
+func TestFilterRetentionTiers(t *testing.T) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	month := time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		tiers   string
+		created map[string]time.Time
+		deleted []string
+	}{
+		{
+			name:  "newest per day",
+			tiers: "day=3",
+			created: map[string]time.Time{
+				"yesterday-old": today.Add(-23 * time.Hour),
+				"yesterday-new": today.Add(-22 * time.Hour),
+				"two-days-ago":  today.Add(-47 * time.Hour),
+				"ten-days-ago":  today.Add(-10 * 24 * time.Hour),
+			},
+			deleted: []string{"yesterday-old", "ten-days-ago"},
+		},
+		{
+			name:  "newest per month",
+			tiers: "month=2",
+			created: map[string]time.Time{
+				"last-month-old": month.AddDate(0, -1, 9),
+				"last-month-new": month.AddDate(0, -1, 14),
+				"half-year-ago":  month.AddDate(0, -6, 0),
+			},
+			deleted: []string{"last-month-old", "half-year-ago"},
+		},
+		{
+			name:  "tiers combined",
+			tiers: "day=3,month=2",
+			created: map[string]time.Time{
+				"yesterday-old":  today.Add(-23 * time.Hour),
+				"yesterday-new":  today.Add(-22 * time.Hour),
+				"last-month-old": month.AddDate(0, -1, 9),
+				"last-month-new": month.AddDate(0, -1, 14),
+			},
+			deleted: []string{"yesterday-old", "last-month-old"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setConfig(t, Config{})
+			var tiers retentionTiers
+			if err := tiers.Set(test.tiers); err != nil {
+				t.Fatal(err)
+			}
+			var images []*Image
+			for tag, created := range test.created {
+				images = append(images, &Image{Name: "app", Tag: tag, Created: created})
+			}
+
+			if got := imageTags(filterRetentionTiers(images, tiers)); !sameTags(got, test.deleted) {
+				t.Errorf("expected %v, got %v", test.deleted, got)
+			}
+		})
+	}
+}
+
+func TestFilterKeepPercent(t *testing.T) {
+	tests := []struct {
+		count   int
+		percent int
+		deleted int
+	}{
+		{count: 10, percent: 30, deleted: 7},
+		{count: 3, percent: 50, deleted: 1},
+		{count: 4, percent: 0, deleted: 4},
+		{count: 4, percent: 100, deleted: 0},
+		{count: 0, percent: 50, deleted: 0},
+	}
+	for _, test := range tests {
+		setConfig(t, Config{})
+		var images []*Image
+		for i := 0; i < test.count; i++ {
+			images = append(images, &Image{Name: "app", Tag: string(rune('a' + i)), Created: time.Now().AddDate(0, 0, -i)})
+		}
+
+		// The oldest images are deleted
+		got := filterKeepPercent(images, test.percent)
+		if len(got) != test.deleted {
+			t.Errorf("%d%% of %d: expected %d deletions, got %d", test.percent, test.count, test.deleted, len(got))
+			continue
+		}
+		for i, image := range got {
+			if want := string(rune('a' + test.count - test.deleted + i)); image.Tag != want {
+				t.Errorf("%d%% of %d: expected %s to be deleted, got %s", test.percent, test.count, want, image.Tag)
+			}
+		}
+	}
+}
+
+// sameTiers returns true if both tiers are equal
+func sameTiers(a, b retentionTiers) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
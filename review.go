@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// reviewCandidates lets the user select the images to delete and returns the selection.
+// Deselected images are kept.
+func reviewCandidates(images []*Image) ([]*Image, error) {
+	// Fail fast if nobody can answer
+	if !isTerminal(os.Stdin) {
+		return nil, errors.New("stdin is not a terminal, -review needs an interactive terminal")
+	}
+
+	// All candidates are selected initially
+	selected := make([]bool, len(images))
+	for i := range selected {
+		selected[i] = true
+	}
+
+	// Read lines in background so the review can time out and be interrupted
+	lines := make(chan string)
+	go func() {
+		reader := bufio.NewReader(os.Stdin)
+		for {
+			text, err := reader.ReadString('\n')
+			if err != nil {
+				close(lines)
+				return
+			}
+			lines <- text
+		}
+	}()
+
+	for {
+		printCandidates(images, selected)
+		fmt.Println("Toggle numbers or ranges (e.g. 1 3-5), a = select all, n = select none, d = delete selected, q = abort")
+		fmt.Printf("> ")
+		var text string
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				return nil, nil
+			}
+			text = line
+		case <-time.After(Cfg.PromptTimeout):
+			fmt.Println("\nNo answer received, aborting")
+			return nil, nil
+		case <-interrupted:
+			return nil, nil
+		}
+
+		switch command := strings.TrimSpace(text); command {
+		case "a", "n":
+			for i := range selected {
+				selected[i] = command == "a"
+			}
+		case "q":
+			return nil, nil
+		case "d":
+			var result []*Image
+			for i, image := range images {
+				if selected[i] {
+					result = append(result, image)
+				} else {
+					image.Result = ""
+					image.Reason = "deselected in review"
+				}
+			}
+			return result, nil
+		default:
+			if err := toggleCandidates(selected, command); err != nil {
+				fmt.Println(err.Error())
+			}
+		}
+	}
+}
+
+// toggleCandidates toggles the selection of the candidates given as numbers or ranges
+func toggleCandidates(selected []bool, command string) error {
+	for _, field := range strings.Fields(command) {
+		// Parse number or range
+		bounds := strings.SplitN(field, "-", 2)
+		from, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return fmt.Errorf("invalid number %q", field)
+		}
+		to := from
+		if len(bounds) == 2 {
+			if to, err = strconv.Atoi(bounds[1]); err != nil {
+				return fmt.Errorf("invalid range %q", field)
+			}
+		}
+		if from < 1 || to > len(selected) || from > to {
+			return fmt.Errorf("%q is out of range 1-%d", field, len(selected))
+		}
+
+		for i := from - 1; i < to; i++ {
+			selected[i] = !selected[i]
+		}
+	}
+	return nil
+}
+
+// printCandidates prints the candidates with age, size and cluster usage
+func printCandidates(images []*Image, selected []bool) {
+	count := 0
+	fmt.Println()
+	for i, image := range images {
+		mark := " "
+		if selected[i] {
+			mark = "x"
+			count++
+		}
+		age := "unknown"
+		if !image.Created.IsZero() {
+			age = fmt.Sprintf("%dd", int(time.Since(image.Created).Hours()/24))
+		}
+		clusters := "-"
+		if len(image.Clusters) > 0 {
+			clusters = strings.Join(image.Clusters, ",")
+		}
+		fmt.Printf("%4d [%s] %-60s %8s %10s %s\n", i+1, mark, image.Name+":"+image.Tag, age, formatBytes(image.Size), clusters)
+	}
+	fmt.Printf("%d of %d images selected\n", count, len(images))
+}
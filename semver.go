@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// semverRegexp matches tags like 1.2.3, v1.2.3-rc.1 or 1.2.3+build
+var semverRegexp = regexp.MustCompile(`^v?(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)(?:-([0-9A-Za-z.-]+))?(?:\+[0-9A-Za-z.-]+)?$`)
+
+// Version represents a parsed semantic version tag
+type Version struct {
+	Major      int
+	Minor      int
+	Patch      int
+	PreRelease string
+}
+
+// parseVersion parses the tag as semantic version
+func parseVersion(tag string) (*Version, bool) {
+	match := semverRegexp.FindStringSubmatch(tag)
+	if match == nil {
+		return nil, false
+	}
+	v := &Version{PreRelease: match[4]}
+	v.Major, _ = strconv.Atoi(match[1])
+	v.Minor, _ = strconv.Atoi(match[2])
+	v.Patch, _ = strconv.Atoi(match[3])
+	return v, true
+}
+
+// less returns true if v has a lower precedence than o. Pre-releases are compared lexically.
+func (v *Version) less(o *Version) bool {
+	switch {
+	case v.Major != o.Major:
+		return v.Major < o.Major
+	case v.Minor != o.Minor:
+		return v.Minor < o.Minor
+	case v.Patch != o.Patch:
+		return v.Patch < o.Patch
+	case v.PreRelease == o.PreRelease:
+		return false
+	case v.PreRelease == "":
+		return false
+	case o.PreRelease == "":
+		return true
+	default:
+		return v.PreRelease < o.PreRelease
+	}
+}
+
+// splitSemver separates images with semantic version tags from the other images
+func splitSemver(images []*Image) ([]*Image, []*Image) {
+	var versioned []*Image
+	i := 0
+	for _, image := range images {
+		if _, ok := parseVersion(image.Tag); ok {
+			versioned = append(versioned, image)
+		} else {
+			// This image should stay in slice
+			images[i] = image
+			i++
+		}
+	}
+	return images[:i], versioned
+}
+
+// expireSemver returns the versioned images which are neither part of the latest major
+// versions nor one of the latest patch releases of their minor version
+func expireSemver(images []*Image) []*Image {
+	// Sort by version, highest first
+	versions := make(map[*Image]*Version)
+	for _, image := range images {
+		versions[image], _ = parseVersion(image.Tag)
+	}
+	sort.Slice(images, func(a, b int) bool {
+		return versions[images[b]].less(versions[images[a]])
+	})
+
+	var expired []*Image
+	majors := make(map[int]bool)
+	patches := make(map[string]int)
+	for _, image := range images {
+		v := versions[image]
+
+		// Keep all tags of the latest major versions
+		if majors[v.Major] || len(majors) < Cfg.SemverMajors {
+			majors[v.Major] = true
+			logf(lineKept, "Image %s:%s is part of the latest %d major versions, skipped\n", image.Name, image.Tag, Cfg.SemverMajors)
+			image.Reason = "latest major version"
+			continue
+		}
+
+		// Keep the latest patch releases per minor version
+		minor := fmt.Sprintf("%d.%d", v.Major, v.Minor)
+		if patches[minor] < Cfg.SemverPatches {
+			patches[minor]++
+			logf(lineKept, "Image %s:%s is one of the latest %d patch releases of %s, skipped\n", image.Name, image.Tag, Cfg.SemverPatches, minor)
+			image.Reason = "latest patch release"
+			continue
+		}
+		expired = append(expired, image)
+	}
+	return expired
+}
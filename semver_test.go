@@ -0,0 +1,105 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		tag     string
+		version *Version
+	}{
+		{tag: "1.2.3", version: &Version{Major: 1, Minor: 2, Patch: 3}},
+		{tag: "v10.0.1-rc.1", version: &Version{Major: 10, Minor: 0, Patch: 1, PreRelease: "rc.1"}},
+		{tag: "1.2.3+build.5", version: &Version{Major: 1, Minor: 2, Patch: 3}},
+		{tag: "1.2"},
+		{tag: "01.2.3"},
+		{tag: "latest"},
+	}
+	for _, test := range tests {
+		v, ok := parseVersion(test.tag)
+		if ok != (test.version != nil) {
+			t.Errorf("%s: expected version %v, got %v", test.tag, test.version != nil, ok)
+			continue
+		}
+		if ok && *v != *test.version {
+			t.Errorf("%s: expected %+v, got %+v", test.tag, *test.version, *v)
+		}
+	}
+}
+
+func TestVersionLess(t *testing.T) {
+	tests := []struct {
+		a, b string
+		less bool
+	}{
+		{a: "1.2.3", b: "2.0.0", less: true},
+		{a: "1.2.3", b: "1.10.0", less: true},
+		{a: "1.2.3", b: "1.2.10", less: true},
+		{a: "1.2.3", b: "1.2.3", less: false},
+		{a: "1.2.3-rc.1", b: "1.2.3", less: true},
+		{a: "1.2.3", b: "1.2.3-rc.1", less: false},
+		{a: "1.2.3-alpha", b: "1.2.3-beta", less: true},
+		{a: "v1.2.3", b: "1.2.3+build", less: false},
+		{a: "2.0.0", b: "1.9.9", less: false},
+	}
+	for _, test := range tests {
+		a, _ := parseVersion(test.a)
+		b, _ := parseVersion(test.b)
+		if less := a.less(b); less != test.less {
+			t.Errorf("%s < %s: expected %v, got %v", test.a, test.b, test.less, less)
+		}
+	}
+}
+
+func TestExpireSemver(t *testing.T) {
+	tests := []struct {
+		name    string
+		majors  int
+		patches int
+		tags    []string
+		expired []string
+	}{
+		{
+			name:    "latest patches per minor",
+			majors:  0,
+			patches: 2,
+			tags:    []string{"1.0.1", "1.0.2", "1.0.3", "1.1.0", "1.1.1-rc.1", "1.1.1"},
+			expired: []string{"1.1.0", "1.0.1"},
+		},
+		{
+			name:    "latest major kept",
+			majors:  1,
+			patches: 1,
+			tags:    []string{"2.0.0", "2.0.1", "1.0.0", "1.0.1", "1.1.0"},
+			expired: []string{"1.0.0"},
+		},
+		{
+			name:    "two majors kept",
+			majors:  2,
+			patches: 1,
+			tags:    []string{"3.0.0", "2.0.0", "2.0.1", "1.0.0", "1.0.1"},
+			expired: []string{"1.0.0"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setConfig(t, Config{SemverMajors: test.majors, SemverPatches: test.patches})
+			var images []*Image
+			for _, tag := range test.tags {
+				images = append(images, &Image{Name: "app", Tag: tag})
+			}
+
+			// Expired images are returned highest version first
+			got := imageTags(expireSemver(images))
+			if len(got) != len(test.expired) {
+				t.Fatalf("expected %v, got %v", test.expired, got)
+			}
+			for i := range got {
+				if got[i] != test.expired[i] {
+					t.Fatalf("expected %v, got %v", test.expired, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrorContext is what the run was working on when an error happened, reported with the error
+type ErrorContext struct {
+	Repository string
+	Tag        string
+	Phase      string
+	Stack      []byte
+}
+
+// errorContext is the phase of the run and the stack of an unexpected panic. The repository
+// and tag are passed with the error, see withContext.
+var errorContext struct {
+	ErrorContext
+	sync.Mutex
+}
+
+// setErrorPhase remembers the phase of the run and returns the previous phase
+func setErrorPhase(phase string) string {
+	errorContext.Lock()
+	defer errorContext.Unlock()
+	previous := errorContext.Phase
+	errorContext.Phase = phase
+	return previous
+}
+
+// newErrorContext returns the context of an error of the repository and tag in the current phase
+func newErrorContext(repository, tag string) ErrorContext {
+	errorContext.Lock()
+	defer errorContext.Unlock()
+	return ErrorContext{Repository: repository, Tag: tag, Phase: errorContext.Phase}
+}
+
+// contextOf returns the context added to the error, or the phase of the run
+func contextOf(err error) ErrorContext {
+	if ctx := workerContext(err); ctx != nil {
+		return *ctx
+	}
+	errorContext.Lock()
+	defer errorContext.Unlock()
+	return errorContext.ErrorContext
+}
+
+// capturePanic keeps the stack of the first recovered panic, panics passed on
+// by deferred functions would hide where it happened
+func capturePanic() {
+	errorContext.Lock()
+	defer errorContext.Unlock()
+	if errorContext.Stack == nil {
+		errorContext.Stack = debug.Stack()
+	}
+}
+
+// reportError sends the error with its context to Sentry
+func reportError(err error, ctx ErrorContext) {
+	if Cfg.SentryDSN == "" {
+		return
+	}
+
+	// Parse DSN https://key@host/project
+	dsn, perr := url.Parse(Cfg.SentryDSN)
+	if perr != nil || dsn.User == nil {
+		fmt.Fprintln(os.Stderr, "Invalid Sentry DSN")
+		return
+	}
+	project := strings.TrimPrefix(dsn.Path, "/")
+	storeURL := fmt.Sprintf("%s://%s/api/%s/store/?sentry_version=7&sentry_key=%s", dsn.Scheme, dsn.Host, project, dsn.User.Username())
+
+	// Create event
+	event := map[string]interface{}{
+		"event_id":  randomID(16),
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+		"level":     "error",
+		"platform":  "go",
+		"logger":    "gitlab-registry-pruner",
+		"exception": map[string]interface{}{
+			"values": []map[string]string{{"type": fmt.Sprintf("%T", err), "value": err.Error()}},
+		},
+		"tags": map[string]string{
+			"repository": ctx.Repository,
+			"tag":        ctx.Tag,
+			"phase":      ctx.Phase,
+			"dry_run":    fmt.Sprint(!Cfg.DeleteImages),
+		},
+		"extra": map[string]string{"stack": string(ctx.Stack)},
+	}
+
+	sendNotification("Sentry", func() error {
+		return postJSON(storeURL, event)
+	})
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics of all runs of the daemon
+var (
+	serveLock       sync.Mutex
+	lastSummary     *Summary
+	runsTotal       int
+	runsFailed      int
+	deletedTotal    int
+	failuresTotal   int
+	requestDuration = newHistogram([]float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30})
+)
+
+// serve prunes on a schedule and exposes the metrics of the runs on /metrics
+func serve(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	listen := fs.String("listen", ":9090", "Address the /metrics endpoint listens on")
+	interval := fs.Duration("interval", 24*time.Hour, "Time between the start of two runs")
+	parseFlags(fs, args)
+
+	// Nobody can answer the confirmation prompt of a daemon
+	if Cfg.DeleteImages && (!Cfg.Yes || Cfg.Review) {
+		return errors.New("the serve command requires -yes and can't be used with -review")
+	}
+
+	// Measure the latency of all requests
+	httpClient.Transport = &metricsTransport{httpClient.Transport}
+
+	// Serve metrics
+	http.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		fmt.Fprint(w, serveMetrics())
+	})
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return fmt.Errorf("cannot serve metrics on %s: %w", *listen, err)
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- http.Serve(ln, nil)
+	}()
+	fmt.Printf("Serving metrics on %s/metrics, pruning every %s\n", *listen, *interval)
+
+	// Prune on schedule
+	repositories := Cfg.Repositories
+	for {
+		start := time.Now()
+		Cfg.Repositories = append(repositoryFlags{}, repositories...)
+		s := runScheduled()
+
+		// Update metrics
+		serveLock.Lock()
+		lastSummary = &s
+		runsTotal++
+		if s.Error != "" {
+			runsFailed++
+		}
+		if !s.DryRun {
+			deletedTotal += s.TagsDeleted
+		}
+		failuresTotal += s.Failures
+		serveLock.Unlock()
+
+		// Stop after the run on SIGINT or SIGTERM
+		select {
+		case <-time.After(time.Until(start.Add(*interval))):
+		case err := <-served:
+			return fmt.Errorf("cannot serve metrics on %s: %w", *listen, err)
+		case <-interrupted:
+			fmt.Println("Stopped")
+			os.Exit(exitNothingDeleted)
+		}
+	}
+}
+
+// runScheduled prunes once and returns the summary. Errors don't stop the daemon.
+func runScheduled() (s Summary) {
+	// Reset the state of the previous run
+	resetRun()
+	deletedCount, failedCount = 0, 0
+	resetFailures()
+
+	failed := func(err error) Summary {
+		fmt.Fprintln(os.Stderr, colorize(colorBoldRed, "Error: "+errorMessage(err)))
+		reportError(err, contextOf(err))
+		s := runSummary()
+		s.Error = err.Error()
+		return s
+	}
+
+	defer printFailures()
+	defer func() {
+		// A panic is a bug, the daemon reports it like an error as a last resort
+		if r := recover(); r != nil {
+			capturePanic()
+			s = failed(panicError(r))
+		}
+	}()
+	if err := pruneAll(); err != nil {
+		return failed(err)
+	}
+	return runSummary()
+}
+
+// serveMetrics returns the metrics of the last run and the totals of all runs
+func serveMetrics() string {
+	serveLock.Lock()
+	defer serveLock.Unlock()
+
+	var b strings.Builder
+	counter := func(name, help string, value int) {
+		fmt.Fprintf(&b, "# HELP registry_pruner_%s %s\n# TYPE registry_pruner_%s counter\nregistry_pruner_%s %d\n", name, help, name, name, value)
+	}
+	counter("runs_total", "Number of runs since the start.", runsTotal)
+	counter("runs_failed_total", "Number of runs aborted by an error since the start.", runsFailed)
+	counter("tags_deleted_total", "Number of tags deleted since the start.", deletedTotal)
+	counter("failures_total", "Number of failed deletions since the start.", failuresTotal)
+	b.WriteString(requestDuration.format("registry_pruner_http_request_duration_seconds", "Latency of registry and GitLab requests."))
+	if lastSummary != nil {
+		b.WriteString(formatMetrics(*lastSummary))
+	}
+	return b.String()
+}
+
+// metricsTransport records the latency of every request
+type metricsTransport struct {
+	next http.RoundTripper
+}
+
+func (m *metricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := m.next.RoundTrip(req)
+	requestDuration.observe(time.Since(start).Seconds())
+	return resp, err
+}
+
+// histogram counts observations in cumulative buckets like a Prometheus histogram
+type histogram struct {
+	bounds []float64
+	counts []int
+	sum    float64
+	count  int
+	sync.Mutex
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]int, len(bounds))}
+}
+
+func (h *histogram) observe(value float64) {
+	h.Lock()
+	defer h.Unlock()
+	for i, bound := range h.bounds {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += value
+	h.count++
+}
+
+// format returns the histogram in the Prometheus text exposition format
+func (h *histogram) format(name, help string) string {
+	h.Lock()
+	defer h.Unlock()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	for i, bound := range h.bounds {
+		fmt.Fprintf(&b, "%s_bucket{le=\"%v\"} %d\n", name, bound, h.counts[i])
+	}
+	fmt.Fprintf(&b, "%s_bucket{le=\"+Inf\"} %d\n%s_sum %v\n%s_count %d\n", name, h.count, name, h.sum, name, h.count)
+	return b.String()
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// interrupted is closed on the first SIGINT or SIGTERM
+var interrupted = make(chan struct{})
+
+var handleSignalsOnce sync.Once
+
+// handleSignals traps SIGINT and SIGTERM. The first signal stops issuing new deletions while
+// running requests finish and the summary is written, the second one exits at once.
+func handleSignals() {
+	handleSignalsOnce.Do(func() {
+		signals := make(chan os.Signal, 2)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			sig := <-signals
+			fmt.Fprintf(os.Stderr, "\nReceived %s, no new deletions are started. Send it again to exit at once.\n", sig)
+			close(interrupted)
+			sig = <-signals
+			fmt.Fprintf(os.Stderr, "\nReceived %s again, exiting\n", sig)
+			os.Exit(exitInterrupted)
+		}()
+	})
+}
+
+// isInterrupted returns true once a signal was received
+func isInterrupted() bool {
+	select {
+	case <-interrupted:
+		return true
+	default:
+		return false
+	}
+}
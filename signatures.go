@@ -0,0 +1,413 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"regexp"
+	"strings"
+)
+
+// signatureModes are the values of -signatures
+var signatureModes = map[string]bool{"subject": true, "keep": true, "policy": true}
+
+// signatureTagPattern matches the tags cosign attaches to the image with the digest (sha256-<hex>.sig, .att, .sbom)
+var signatureTagPattern = regexp.MustCompile(`^(sha256)-([0-9a-f]{64})(\.(sig|att|sbom))?$`)
+
+// splitSignatures separates signature tags from the images. Unless signatures are kept,
+// the signatures of images which no longer exist in the repository are returned for deletion.
+func splitSignatures(repo *Repository, images []*Image) ([]*Image, []*Image, error) {
+	// Digests of the images in the repository
+	hasSignatures := false
+	for _, image := range images {
+		hasSignatures = hasSignatures || signatureTagPattern.MatchString(image.Tag)
+	}
+	if !hasSignatures {
+		return images, nil, nil
+	}
+	if offlineInventory == nil {
+		if err := resolveDigests(repo.Images); err != nil {
+			return nil, nil, err
+		}
+	}
+	subjects := make(map[string]bool)
+	for _, image := range repo.Images {
+		subjects[image.Digest] = true
+		for _, child := range image.Children {
+			subjects[child] = true
+		}
+	}
+
+	var orphans []*Image
+	i := 0
+	for _, image := range images {
+		match := signatureTagPattern.FindStringSubmatch(image.Tag)
+		switch {
+		case match == nil:
+			// This image should stay in slice
+			images[i] = image
+			i++
+		case repo.signatures() == "keep":
+			image.Reason = "signature tag"
+		case !subjects[match[1]+":"+match[2]]:
+			logf(lineInfo, "Signature %s:%s belongs to no image of the repository\n", image.Name, image.Tag)
+			orphans = append(orphans, image)
+		default:
+			image.Reason = "signature, deleted with its image"
+		}
+	}
+	return images[:i], orphans, nil
+}
+
+const (
+	cosignSignatureAnnotation   = "dev.cosignproject.cosign/signature"
+	cosignCertificateAnnotation = "dev.sigstore.cosign/certificate"
+	cosignChainAnnotation       = "dev.sigstore.cosign/chain"
+	notationEnvelopeMediaType   = "application/jose+json"
+)
+
+// jwsHashes are the hashes of the JWS algorithms notation signs with
+var jwsHashes = map[string]crypto.Hash{
+	"PS256": crypto.SHA256,
+	"PS384": crypto.SHA384,
+	"PS512": crypto.SHA512,
+	"ES256": crypto.SHA256,
+	"ES384": crypto.SHA384,
+	"ES512": crypto.SHA512,
+}
+
+// trustedSigners are the public keys of -protect-signed-key by file and the roots of -protect-signed-roots
+var trustedSigners struct {
+	keys  map[string]crypto.PublicKey
+	roots *x509.CertPool
+}
+
+type signerFlags []string
+
+// loadTrustedSigners reads the trusted public keys and certificate roots
+func loadTrustedSigners() error {
+	trustedSigners.keys = make(map[string]crypto.PublicKey)
+	for _, file := range Cfg.SignedKeys {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return fmt.Errorf("no PEM public key in %s", file)
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("invalid public key in %s: %v", file, err)
+		}
+		trustedSigners.keys[file] = key
+	}
+
+	if len(Cfg.SignedIdentities) > 0 {
+		if Cfg.SignedRoots == "" {
+			return errors.New("-protect-signed-identity requires -protect-signed-roots")
+		}
+		data, err := ioutil.ReadFile(Cfg.SignedRoots)
+		if err != nil {
+			return err
+		}
+		trustedSigners.roots = x509.NewCertPool()
+		if !trustedSigners.roots.AppendCertsFromPEM(data) {
+			return fmt.Errorf("no certificates in %s", Cfg.SignedRoots)
+		}
+	}
+	return nil
+}
+
+// filterSignedImages removes images with a valid signature of a trusted signer from the slice
+func filterSignedImages(repo *Repository, images []*Image) ([]*Image, error) {
+	if err := resolveDigests(images); err != nil {
+		return nil, err
+	}
+	i := 0
+	for _, image := range images {
+		signer, err := trustedSignature(repo, image)
+		if err != nil {
+			return nil, withContext(err, image.Name, image.Tag)
+		}
+		if signer == "" {
+			// This image should stay in slice
+			images[i] = image
+			i++
+		} else {
+			// Print information
+			logf(lineKept, "Image %s:%s is signed by %s, skipped\n", image.Name, image.Tag, signer)
+			image.Reason = "signed by " + signer
+		}
+	}
+	return images[:i], nil
+}
+
+// trustedSignature returns the trusted signer of a cosign or notation signature of the image, empty if there is none
+func trustedSignature(repo *Repository, image *Image) (string, error) {
+	// Find the cosign signature tag and the signatures of the referrers API
+	var refs []string
+	tag := strings.Replace(image.Digest, ":", "-", 1) + ".sig"
+	for _, other := range repo.Images {
+		if other.Tag == tag {
+			refs = append(refs, tag)
+		}
+	}
+	referrers, err := queryReferrers(repo, image.Digest)
+	if err != nil {
+		return "", err
+	}
+	for _, referrer := range referrers {
+		if artifactTypes(artifactTypeAliases["signature"]).match(referrer.ArtifactType) {
+			refs = append(refs, referrer.Digest)
+		}
+	}
+
+	// Verify the signatures
+	for _, ref := range refs {
+		m, _, err := getManifest(repo, ref)
+		if err != nil {
+			return "", err
+		}
+		for _, layer := range m.Layers {
+			if layer.MediaType != notationEnvelopeMediaType && layer.Annotations[cosignSignatureAnnotation] == "" {
+				continue
+			}
+			blob, err := getBlob(repo, layer.Digest)
+			if err != nil {
+				return "", err
+			}
+			var signer string
+			if layer.MediaType == notationEnvelopeMediaType {
+				signer, err = verifyNotation(blob, image.Digest)
+			} else {
+				signer, err = verifyCosign(layer, blob, image.Digest)
+			}
+			if err != nil {
+				logf(lineInfo, "Signature %s of %s:%s is not trusted: %s\n", ref, image.Name, image.Tag, err.Error())
+				continue
+			}
+			return signer, nil
+		}
+	}
+	return "", nil
+}
+
+// verifyCosign verifies the cosign signature of the simple signing payload
+// with a trusted key or a certificate of a trusted identity
+func verifyCosign(layer Descriptor, payload []byte, digest string) (string, error) {
+	// The payload must be for the image
+	var p struct {
+		Critical struct {
+			Image struct {
+				Digest string `json:"docker-manifest-digest"`
+			} `json:"image"`
+		} `json:"critical"`
+	}
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return "", err
+	}
+	if p.Critical.Image.Digest != digest {
+		return "", fmt.Errorf("signed digest is %s", p.Critical.Image.Digest)
+	}
+	sig, err := base64.StdEncoding.DecodeString(layer.Annotations[cosignSignatureAnnotation])
+	if err != nil {
+		return "", err
+	}
+
+	// Signed with a trusted key
+	for file, key := range trustedSigners.keys {
+		if verifySignature(key, payload, sig, crypto.SHA256, false) == nil {
+			return "key " + file, nil
+		}
+	}
+
+	// Keyless signature with a certificate of a trusted identity
+	if layer.Annotations[cosignCertificateAnnotation] == "" || trustedSigners.roots == nil {
+		return "", errors.New("not signed by a trusted key")
+	}
+	certs := parseCertificates(layer.Annotations[cosignCertificateAnnotation] + "\n" + layer.Annotations[cosignChainAnnotation])
+	identity, err := verifyCertificate(certs)
+	if err != nil {
+		return "", err
+	}
+	if err := verifySignature(certs[0].PublicKey, payload, sig, crypto.SHA256, false); err != nil {
+		return "", err
+	}
+	return identity, nil
+}
+
+// verifyNotation verifies a notation JWS envelope with the certificate chain it contains
+func verifyNotation(envelope []byte, digest string) (string, error) {
+	var jws struct {
+		Payload   string `json:"payload"`
+		Protected string `json:"protected"`
+		Header    struct {
+			X5C [][]byte `json:"x5c"`
+		} `json:"header"`
+		Signature string `json:"signature"`
+	}
+	if err := json.Unmarshal(envelope, &jws); err != nil {
+		return "", err
+	}
+
+	// The payload must be for the image
+	payload, err := base64.RawURLEncoding.DecodeString(jws.Payload)
+	if err != nil {
+		return "", err
+	}
+	var p struct {
+		TargetArtifact Descriptor `json:"targetArtifact"`
+	}
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return "", err
+	}
+	if p.TargetArtifact.Digest != digest {
+		return "", fmt.Errorf("signed digest is %s", p.TargetArtifact.Digest)
+	}
+
+	// Get the algorithm from the protected header
+	protected, err := base64.RawURLEncoding.DecodeString(jws.Protected)
+	if err != nil {
+		return "", err
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := json.Unmarshal(protected, &header); err != nil {
+		return "", err
+	}
+	hash, ok := jwsHashes[header.Alg]
+	if !ok {
+		return "", fmt.Errorf("unsupported algorithm %q", header.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(jws.Signature)
+	if err != nil {
+		return "", err
+	}
+
+	// Verify certificate chain and signature
+	if trustedSigners.roots == nil {
+		return "", errors.New("no trusted identities")
+	}
+	var certs []*x509.Certificate
+	for _, der := range jws.Header.X5C {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return "", err
+		}
+		certs = append(certs, cert)
+	}
+	identity, err := verifyCertificate(certs)
+	if err != nil {
+		return "", err
+	}
+	if err := verifySignature(certs[0].PublicKey, []byte(jws.Protected+"."+jws.Payload), sig, hash, true); err != nil {
+		return "", err
+	}
+	return identity, nil
+}
+
+// verifySignature verifies the signature of the data. JWS signatures use RSA-PSS and
+// fixed size ECDSA values instead of PKCS #1 v1.5 and ASN.1.
+func verifySignature(key crypto.PublicKey, data, sig []byte, hash crypto.Hash, jws bool) error {
+	h := hash.New()
+	h.Write(data)
+	sum := h.Sum(nil)
+
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		if jws {
+			r, s := new(big.Int).SetBytes(sig[:len(sig)/2]), new(big.Int).SetBytes(sig[len(sig)/2:])
+			if ecdsa.Verify(k, sum, r, s) {
+				return nil
+			}
+		} else if ecdsa.VerifyASN1(k, sum, sig) {
+			return nil
+		}
+	case *rsa.PublicKey:
+		if jws {
+			return rsa.VerifyPSS(k, hash, sum, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+		return rsa.VerifyPKCS1v15(k, hash, sum, sig)
+	case ed25519.PublicKey:
+		if ed25519.Verify(k, data, sig) {
+			return nil
+		}
+	}
+	return errors.New("invalid signature")
+}
+
+// verifyCertificate verifies that the signing certificate chains to the trusted roots and
+// has a trusted identity, which is returned
+func verifyCertificate(certs []*x509.Certificate) (string, error) {
+	if len(certs) == 0 {
+		return "", errors.New("no certificate")
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	// Keyless signing certificates are valid for minutes only, so the chain is checked at the time it was issued
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         trustedSigners.roots,
+		Intermediates: intermediates,
+		CurrentTime:   certs[0].NotBefore,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// Match identities
+	identities := []string{certs[0].Subject.String()}
+	identities = append(identities, certs[0].EmailAddresses...)
+	for _, uri := range certs[0].URIs {
+		identities = append(identities, uri.String())
+	}
+	for _, identity := range identities {
+		for _, trusted := range Cfg.SignedIdentities {
+			if identity == trusted {
+				return identity, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("certificate identity %s is not trusted", strings.Join(identities, ", "))
+}
+
+// parseCertificates returns the certificates of the PEM data
+func parseCertificates(data string) []*x509.Certificate {
+	var certs []*x509.Certificate
+	rest := []byte(data)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return certs
+		}
+		if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+			certs = append(certs, cert)
+		}
+	}
+}
+
+func (s *signerFlags) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+func (s *signerFlags) String() string {
+	return ""
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSplitSignatures(t *testing.T) {
+	image := strings.Repeat("a", 64)
+	platform := strings.Repeat("b", 64)
+	gone := strings.Repeat("c", 64)
+	tests := []struct {
+		name    string
+		mode    string
+		tags    []string
+		images  []string
+		orphans []string
+		reason  string
+	}{
+		{
+			name:   "no signatures",
+			mode:   "subject",
+			tags:   []string{"v1", "v2"},
+			images: []string{"v1", "v2"},
+		},
+		{
+			name:   "signatures of existing images",
+			mode:   "subject",
+			tags:   []string{"v1", "sha256-" + image + ".sig", "sha256-" + platform + ".att", "sha256-" + image},
+			images: []string{"v1"},
+			reason: "signature, deleted with its image",
+		},
+		{
+			name:    "orphaned signatures",
+			mode:    "subject",
+			tags:    []string{"v1", "sha256-" + gone + ".sig", "sha256-" + gone + ".sbom"},
+			images:  []string{"v1"},
+			orphans: []string{"sha256-" + gone + ".sig", "sha256-" + gone + ".sbom"},
+		},
+		{
+			name:   "signatures kept",
+			mode:   "keep",
+			tags:   []string{"v1", "sha256-" + image + ".sig", "sha256-" + gone + ".sig"},
+			images: []string{"v1"},
+			reason: "signature tag",
+		},
+		{
+			name:   "no signature pattern",
+			mode:   "subject",
+			tags:   []string{"v1", "sha256-" + image + ".txt", "sha512-" + gone + ".sig"},
+			images: []string{"v1", "sha256-" + image + ".txt", "sha512-" + gone + ".sig"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setConfig(t, Config{Signatures: test.mode})
+
+			// The digests are known offline
+			offlineInventory = &Inventory{}
+			defer func() { offlineInventory = nil }()
+
+			repo := &Repository{Name: "app", Policy: &RepositoryPolicy{}}
+			for _, tag := range test.tags {
+				repo.Images = append(repo.Images, &Image{Name: "app", Tag: tag, Repository: repo})
+			}
+			repo.Images[0].Digest = "sha256:" + image
+			repo.Images[0].Children = []string{"sha256:" + platform}
+			images := append([]*Image(nil), repo.Images...)
+
+			remaining, orphans, err := splitSignatures(repo, images)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := imageTags(remaining); !sameTags(got, test.images) {
+				t.Errorf("expected images %v, got %v", test.images, got)
+			}
+			if got := imageTags(orphans); !sameTags(got, test.orphans) {
+				t.Errorf("expected orphans %v, got %v", test.orphans, got)
+			}
+			for _, image := range repo.Images {
+				if !contains(remaining, image) && !contains(orphans, image) && image.Reason != test.reason {
+					t.Errorf("expected reason %q for %s, got %q", test.reason, image.Tag, image.Reason)
+				}
+			}
+		})
+	}
+}
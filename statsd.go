@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// sendStatsd emits the metrics of the run and of every repository as DogStatsD gauges over UDP
+func sendStatsd(s Summary) error {
+	conn, err := net.Dial("udp", Cfg.StatsdAddress)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Tags appended to every metric
+	tags := strings.Split(Cfg.StatsdTags, ",")
+	if Cfg.StatsdTags == "" {
+		tags = nil
+	}
+	gauge := func(buf *bytes.Buffer, name string, value interface{}, extraTags ...string) {
+		fmt.Fprintf(buf, "%s.%s:%v|g", Cfg.StatsdPrefix, name, value)
+		if all := append(append([]string{}, tags...), extraTags...); len(all) > 0 {
+			fmt.Fprintf(buf, "|#%s", strings.Join(all, ","))
+		}
+		buf.WriteString("\n")
+	}
+
+	// Metrics of the run
+	var buf bytes.Buffer
+	gauge(&buf, "repositories", s.Repositories)
+	gauge(&buf, "tags_scanned", s.TagsScanned)
+	gauge(&buf, "tags_deleted", s.TagsDeleted)
+	gauge(&buf, "bytes_reclaimed", s.BytesReclaimed)
+	gauge(&buf, "failures", s.Failures)
+	gauge(&buf, "error", boolMetric(s.Error != ""))
+	gauge(&buf, "duration_seconds", s.Seconds)
+	if _, err := conn.Write(buf.Bytes()); err != nil {
+		return err
+	}
+
+	// Metrics per repository, one packet each to stay below the UDP packet size
+	for _, repo := range scannedRepositories {
+		var deleted []*Image
+		for _, image := range repo.Images {
+			if image.removed() {
+				deleted = append(deleted, image)
+			}
+		}
+		reclaimed, _ := reclaimableBytes(deleted)
+
+		buf.Reset()
+		tag := "repository:" + repo.Name
+		gauge(&buf, "repository.tags_scanned", repo.TagCount, tag)
+		gauge(&buf, "repository.tags_deleted", len(deleted), tag)
+		gauge(&buf, "repository.bytes_reclaimed", reclaimed, tag)
+		if _, err := conn.Write(buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// allImages returns the images of all scanned repositories
+func allImages() []*Image {
+	var images []*Image
+	for _, repo := range scannedRepositories {
+		images = append(images, repo.Images...)
+	}
+	return images
+}
+
+// reclaimableBytes estimates the bytes freed by deleting the images. Blobs which are still referenced
+// by a kept tag of a scanned repository are not freed and returned as shared bytes. Every blob is
+// counted once, even if several deleted images reference it. Images without known blobs count with their size,
+// images of released repositories with the bytes estimated before their blobs were released.
+func reclaimableBytes(deleted []*Image) (freed, shared int64) {
+	// Blobs of the kept images
+	isDeleted := make(map[*Image]bool)
+	for _, image := range deleted {
+		isDeleted[image] = true
+	}
+	kept := make(map[string]bool)
+	for _, image := range allImages() {
+		if isDeleted[image] {
+			continue
+		}
+		for _, blob := range image.Blobs {
+			kept[blob.Digest] = true
+		}
+	}
+
+	// Sum the blobs of the deleted images
+	counted := make(map[string]bool)
+	for _, image := range deleted {
+		if len(image.Blobs) == 0 {
+			if image.Repository != nil && image.Repository.Released {
+				freed += image.Freed
+			} else {
+				freed += image.Size
+			}
+			continue
+		}
+		for _, blob := range image.Blobs {
+			if counted[blob.Digest] {
+				continue
+			}
+			counted[blob.Digest] = true
+			if kept[blob.Digest] {
+				shared += blob.Size
+			} else {
+				freed += blob.Size
+			}
+		}
+	}
+	return freed, shared
+}
+
+// report runs the report given as first argument
+func report(args []string) error {
+	if len(args) == 0 || args[0] != "storage" {
+		fmt.Fprintln(os.Stderr, "Usage: gitlab-registry-pruner report storage [-top n]")
+		return errUsage
+	}
+	return storageReport(args[1:])
+}
+
+// storageReport prints the storage used per repository and the largest tags. Blobs referenced
+// by several tags are counted once, exclusive bytes are only referenced by the repository or tag.
+func storageReport(args []string) error {
+	fs := flag.NewFlagSet("report storage", flag.ContinueOnError)
+	top := fs.Int("top", 20, "Number of largest tags to print")
+	parseFlags(fs, args)
+
+	// --- Get all images with their blobs ---
+	images, err := getAllImages()
+	if err != nil {
+		return err
+	}
+
+	// --- Count the references of every blob ---
+	blobSizes := make(map[string]int64)
+	imageRefs := make(map[string]int)
+	repoRefs := make(map[string]map[string]bool)
+	for _, image := range images {
+		for digest, size := range imageBlobs(image) {
+			blobSizes[digest] = size
+			imageRefs[digest]++
+			if repoRefs[digest] == nil {
+				repoRefs[digest] = make(map[string]bool)
+			}
+			repoRefs[digest][image.Name] = true
+		}
+	}
+
+	// --- Sum the blobs per repository ---
+	type usage struct {
+		name      string
+		tags      int
+		size      int64
+		exclusive int64
+	}
+	var repos []*usage
+	for _, repo := range scannedRepositories {
+		u := &usage{name: repo.Name, tags: len(repo.Images)}
+		counted := make(map[string]bool)
+		for _, image := range repo.Images {
+			for digest, size := range imageBlobs(image) {
+				if counted[digest] {
+					continue
+				}
+				counted[digest] = true
+				u.size += size
+				if len(repoRefs[digest]) == 1 {
+					u.exclusive += size
+				}
+			}
+		}
+		repos = append(repos, u)
+	}
+	sort.Slice(repos, func(a, b int) bool {
+		return repos[a].size > repos[b].size
+	})
+
+	fmt.Printf("%-60s %6s %10s %10s\n", "REPOSITORY", "TAGS", "SIZE", "EXCLUSIVE")
+	for _, u := range repos {
+		fmt.Printf("%-60s %6d %10s %10s\n", u.name, u.tags, formatBytes(u.size), formatBytes(u.exclusive))
+	}
+	var total, undeduplicated int64
+	for _, size := range blobSizes {
+		total += size
+	}
+	for _, image := range images {
+		undeduplicated += image.Size
+	}
+	fmt.Printf("%d repositories, %d tags, %s (%s without deduplication)\n\n",
+		len(repos), len(images), formatBytes(total), formatBytes(undeduplicated))
+
+	// --- Sum the blobs per tag ---
+	var tags []*usage
+	for _, image := range images {
+		u := &usage{name: image.Name + ":" + image.Tag, size: image.Size}
+		for digest, size := range imageBlobs(image) {
+			if imageRefs[digest] == 1 {
+				u.exclusive += size
+			}
+		}
+		tags = append(tags, u)
+	}
+	sort.Slice(tags, func(a, b int) bool {
+		return tags[a].size > tags[b].size
+	})
+	if len(tags) > *top {
+		tags = tags[:*top]
+	}
+
+	fmt.Printf("%-60s %10s %10s\n", "TAG", "SIZE", "EXCLUSIVE")
+	for _, u := range tags {
+		fmt.Printf("%-60s %10s %10s\n", u.name, formatBytes(u.size), formatBytes(u.exclusive))
+	}
+	return nil
+}
+
+// imageBlobs returns the sizes of the blobs of the image by digest. Images without
+// known blobs are treated as a single blob identified by the image digest.
+func imageBlobs(image *Image) map[string]int64 {
+	blobs := make(map[string]int64)
+	for _, blob := range image.Blobs {
+		blobs[blob.Digest] = blob.Size
+	}
+	if len(blobs) == 0 {
+		blobs[image.Digest] = image.Size
+	}
+	return blobs
+}
+
+// byteSize is a size flag with an optional unit, e.g. 200GB or 1.5TiB
+type byteSize int64
+
+var byteUnits = map[string]float64{
+	"":    1,
+	"B":   1,
+	"KB":  1e3,
+	"MB":  1e6,
+	"GB":  1e9,
+	"TB":  1e12,
+	"KIB": 1 << 10,
+	"MIB": 1 << 20,
+	"GIB": 1 << 30,
+	"TIB": 1 << 40,
+}
+
+func (b *byteSize) Set(value string) error {
+	size := strings.ToUpper(strings.TrimSpace(value))
+	i := strings.IndexFunc(size, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i < 0 {
+		i = len(size)
+	}
+	unit, ok := byteUnits[strings.TrimSpace(size[i:])]
+	number, err := strconv.ParseFloat(size[:i], 64)
+	if !ok || err != nil || number < 0 {
+		return fmt.Errorf("invalid size %q, expected a number with an optional unit like 200GB", value)
+	}
+	*b = byteSize(number * unit)
+	return nil
+}
+
+func (b *byteSize) String() string {
+	if *b == 0 {
+		return ""
+	}
+	return formatBytes(int64(*b))
+}
+
+// sortCandidates orders the candidates by the -strategy, the first ones are deleted first
+func sortCandidates(images []*Image) error {
+	if Cfg.Strategy != "size" {
+		sort.SliceStable(images, func(a, b int) bool {
+			return images[a].Created.Before(images[b].Created)
+		})
+		return nil
+	}
+
+	// Count the references of all blobs of the scanned tags
+	if offlineInventory == nil {
+		if err := setImageDigest(allImages()); err != nil {
+			return err
+		}
+	}
+	refs := make(map[string]int)
+	for _, image := range allImages() {
+		for digest := range imageBlobs(image) {
+			refs[digest]++
+		}
+	}
+
+	// Sort by the bytes only the image references
+	reclaimable := make(map[*Image]int64)
+	for _, image := range images {
+		for digest, size := range imageBlobs(image) {
+			if refs[digest] == 1 {
+				reclaimable[image] += size
+			}
+		}
+	}
+	sort.SliceStable(images, func(a, b int) bool {
+		return reclaimable[images[a]] > reclaimable[images[b]]
+	})
+	return nil
+}
+
+// filterTargetSize keeps the candidates of every repository except the first ones of the
+// -strategy which must be deleted to bring the estimated storage of the repository below the target
+func filterTargetSize(images []*Image, target int64) ([]*Image, error) {
+	// Group candidates by repository
+	var repos []*Repository
+	candidates := make(map[*Repository][]*Image)
+	for _, image := range images {
+		if candidates[image.Repository] == nil {
+			repos = append(repos, image.Repository)
+		}
+		candidates[image.Repository] = append(candidates[image.Repository], image)
+	}
+
+	var result []*Image
+	for _, repo := range repos {
+		// Count the references of the blobs of all tags
+		if offlineInventory == nil {
+			if err := setImageDigest(repo.Images); err != nil {
+				return nil, err
+			}
+		}
+		refs := make(map[string]int)
+		var size int64
+		for _, image := range repo.Images {
+			for digest, blobSize := range imageBlobs(image) {
+				if refs[digest] == 0 {
+					size += blobSize
+				}
+				refs[digest]++
+			}
+		}
+		before := size
+
+		// Delete the candidates in the order of the strategy until the repository is small enough
+		repoImages := candidates[repo]
+		if err := sortCandidates(repoImages); err != nil {
+			return nil, err
+		}
+		deleted := 0
+		for _, image := range repoImages {
+			if size <= target {
+				image.Reason = "repository below target size"
+				continue
+			}
+			for digest, blobSize := range imageBlobs(image) {
+				if refs[digest]--; refs[digest] == 0 {
+					size -= blobSize
+				}
+			}
+			result = append(result, image)
+			deleted++
+		}
+		fmt.Printf("Repository %s uses about %s, deleting %d images reduces it to %s (target %s)\n",
+			repo.Name, formatBytes(before), deleted, formatBytes(size), formatBytes(target))
+	}
+	return result, nil
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// pruneStream deletes the images repository by repository instead of collecting the images of all
+// repositories first. The decisions of a repository are written to -output and -audit-log and its
+// kept images are released before the next one is scanned, so the memory needed depends on the
+// largest repository and the removed and protected images instead of every tag of the registry.
+// Layers shared with repositories processed before are not known anymore and count as reclaimed.
+func pruneStream() (err error) {
+	// Every repository is decided on its own, checks of the whole run don't work
+	switch {
+	case Cfg.TagsFrom != "":
+		return errors.New("-stream can't be used with -tags-from")
+	case Cfg.TombstoneFile != "":
+		return errors.New("-stream can't be used with -tombstone-file")
+	case Cfg.Review:
+		return errors.New("-stream can't be used with -review")
+	case Cfg.Checkpoint != "":
+		return errors.New("-stream can't be used with -checkpoint")
+	case Cfg.MaxDeletions > 0 && !Cfg.TruncateDeletions:
+		return errors.New("-stream can only stop at -max-deletions, add -max-deletions-truncate")
+	case Cfg.DeleteImages && !Cfg.Yes:
+		return errors.New("-stream deletes without asking for confirmation, add -yes")
+	case Cfg.ReportHTML != "":
+		return errors.New("-stream can't be used with -report-html, it lists every tag")
+	case Cfg.HistoryDB != "":
+		return errors.New("-stream can't be used with -history-db, it stores every tag")
+	}
+
+	// --- Finish running requests on SIGINT or SIGTERM ---
+	handleSignals()
+
+	// --- Trace the run if requested ---
+	span := startSpan("prune")
+	defer func() { exportTraces(span, err) }()
+
+	// --- Send the summary of the run when done ---
+	defer func() { notify(err) }()
+
+	if Cfg.Report != "" {
+		defer func() { err = firstError(err, writeReport()) }()
+	}
+
+	// --- Write the decisions of every repository once it is done ---
+	var out *outputWriter
+	if Cfg.Output != "" {
+		if out, err = openOutput(); err != nil {
+			return err
+		}
+		defer func() { err = firstError(err, out.close()) }()
+	}
+	done := func(repo *Repository) error {
+		if out != nil {
+			out.write(repo)
+		}
+		if Cfg.AuditLog != "" {
+			if err := auditRepository(repo); err != nil {
+				return err
+			}
+		}
+		repo.release()
+		return nil
+	}
+
+	// --- Find the repositories ---
+	if err := prepareRepositories(); err != nil {
+		return err
+	}
+
+	// --- Scan the clusters once, the images of every repository are looked up in the result ---
+	var refs []imageReference
+	clusters, err := getClusters()
+	if err != nil {
+		return err
+	}
+	scan := offlineInventory == nil && len(clusters)+len(Cfg.ECSClusters) > 0
+	if scan {
+		if refs, err = collectImageReferences(); err != nil {
+			return err
+		}
+	}
+
+	// --- Delete the images of one repository after the other ---
+	var total int
+	for i, path := range Cfg.Repositories {
+		if isInterrupted() {
+			fmt.Printf("Interrupted, %d repositories skipped\n", len(Cfg.Repositories)-i)
+			break
+		}
+
+		// Stop at the maximum number of deletions
+		remaining := 0
+		if Cfg.MaxDeletions > 0 {
+			if remaining = Cfg.MaxDeletions - total; remaining <= 0 {
+				fmt.Printf("Maximum of %d deletions reached, %d repositories skipped\n", Cfg.MaxDeletions, len(Cfg.Repositories)-i)
+				break
+			}
+		}
+
+		// Collect the images to delete, a failed repository is skipped
+		var repo *Repository
+		var images []*Image
+		ok, err := tolerate("scan repository", path, "", func() error {
+			var err error
+			repo = newRepository(path)
+			if images, err = getCandidates(repo); err != nil {
+				return err
+			}
+			if scan {
+				if err := resolveDigests(images); err != nil {
+					return err
+				}
+				markUsedImages(images, refs)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if !ok {
+			if repo != nil {
+				if err := done(repo); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if images, err = limitDeletions(filterUsedImages(images), remaining); err != nil {
+			return err
+		}
+		for _, image := range images {
+			logf(lineDeleted, "%sImage will be deleted: %s:%s\n", dryRunPrefix(), image.Name, image.Tag)
+			image.Result = "delete"
+		}
+		freed, shared := reclaimableBytes(images)
+		fmt.Printf("%s%d images of %s will be deleted, about %s can be reclaimed (%s of their layers are shared with kept tags)\n",
+			dryRunPrefix(), len(images), repo.Name, formatBytes(freed), formatBytes(shared))
+
+		// Delete them
+		if Cfg.DeleteImages && len(images) > 0 {
+			if err := resolveDigests(images); err != nil {
+				return err
+			}
+			if Cfg.Trash {
+				if err := trashImages(images); err != nil {
+					return err
+				}
+			}
+			if err := deleteImages(images); err != nil {
+				return err
+			}
+		}
+		total += len(images)
+		if err := done(repo); err != nil {
+			return err
+		}
+	}
+
+	// --- Free the disk space once ---
+	summary := runSummary()
+	verb := "will be deleted"
+	if Cfg.DeleteImages {
+		verb = "were deleted"
+	}
+	fmt.Printf("%s%d images of %d repositories %s, about %s can be reclaimed\n",
+		dryRunPrefix(), summary.TagsDeleted, summary.Repositories, verb, formatBytes(summary.BytesReclaimed))
+	printDryRunNotice()
+	if deletedCount > 0 && !isInterrupted() {
+		collectGarbage()
+	}
+	return nil
+}
+
+// release drops the blobs of the images and the kept images once the repository is processed.
+// Removed images keep the bytes their deletion frees, blobs shared by several removed images
+// count for the first one. Protected images stay for the report.
+func (r *Repository) release() {
+	kept := make(map[string]bool)
+	for _, image := range r.Images {
+		if !image.removed() {
+			for _, blob := range image.Blobs {
+				kept[blob.Digest] = true
+			}
+		}
+	}
+	counted := make(map[string]bool)
+	for _, image := range r.Images {
+		if image.removed() {
+			image.Freed = 0
+			if len(image.Blobs) == 0 {
+				image.Freed = image.Size
+			}
+			for _, blob := range image.Blobs {
+				if !kept[blob.Digest] && !counted[blob.Digest] {
+					counted[blob.Digest] = true
+					image.Freed += blob.Size
+				}
+			}
+		}
+		image.Blobs = nil
+	}
+	var listed []*Image
+	for _, image := range r.Images {
+		if image.decision() != "keep" || image.protected() {
+			listed = append(listed, image)
+		}
+	}
+	r.Images = listed
+	r.Released = true
+}
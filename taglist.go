@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// TagList is a list of tags or glob patterns read from a file
+type TagList []string
+
+// Lists of tags given by -protect-file and -delete-file
+var protectList, deleteList TagList
+
+// loadTagList reads one tag or glob pattern per line. Empty lines and lines starting with # are ignored.
+func loadTagList(file string) (TagList, error) {
+	if file == "" {
+		return nil, nil
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	list := TagList{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, err := path.Match(line, ""); err != nil {
+			return nil, err
+		}
+		list = append(list, line)
+	}
+	return list, scanner.Err()
+}
+
+// match returns the first tag or pattern matching the tag
+func (t TagList) match(tag string) (string, bool) {
+	for _, pattern := range t {
+		if ok, _ := path.Match(pattern, tag); ok {
+			return pattern, true
+		}
+	}
+	return "", false
+}
+
+// isDeleteListed returns true if no delete regex or list is given or the tag matches one of them
+func isDeleteListed(tag string, deleteRegexps Regexps) bool {
+	if len(deleteRegexps) == 0 && deleteList == nil {
+		return true
+	}
+	if _, matched := deleteRegexps.match(tag); matched {
+		return true
+	}
+	_, matched := deleteList.match(tag)
+	return matched
+}
+
+// getListedImages returns the images listed in the file (one tag or repository:tag per line) which
+// exist in the registry. Plain tags apply to all repositories given by -repository.
+func getListedImages(file string) ([]*Image, error) {
+	// Read listed tags
+	var r io.Reader = os.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	listed := make(map[string]bool)
+	paths := append([]string{}, Cfg.Repositories...)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		listed[line] = true
+
+		// Repositories of listed repository:tag lines are processed as well
+		if i := strings.LastIndex(line, ":"); i > 0 {
+			paths = append(paths, line[:i])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// Match listed tags with existing tags
+	var images []*Image
+	seen := make(map[string]bool)
+	for _, p := range paths {
+		repo := newRepository(p)
+		if seen[repo.Name] {
+			continue
+		}
+		seen[repo.Name] = true
+		token, err := getRegistryToken(repo)
+		if err != nil {
+			return nil, withContext(err, repo.Name, "")
+		}
+		repo.Token = token
+
+		repoImages, err := getImages(repo)
+		if err != nil {
+			return nil, withContext(err, repo.Name, "")
+		}
+		for _, image := range repoImages {
+			if listed[image.Tag] || listed[p+":"+image.Tag] || listed[repo.Name+":"+image.Tag] {
+				images = append(images, image)
+			}
+		}
+	}
+	fmt.Printf("%d listed tags found in the registry\n", len(images))
+	return filterWellKnownTags(images), nil
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenRefreshMargin is how long before it expires a token is renewed
+const tokenRefreshMargin = 30 * time.Second
+
+// registryToken is a registry token with its scopes and the token which replaced it
+type registryToken struct {
+	scopes  []string
+	current string
+	expires time.Time
+}
+
+// Registry tokens by their value. Tokens expire after a few minutes, the code holding
+// a token keeps using it and tokenTransport sends its replacement instead.
+var registryTokens = struct {
+	byToken map[string]*registryToken
+	sync.Mutex
+}{byToken: make(map[string]*registryToken)}
+
+// rememberToken registers the token so it can be renewed when it expires
+func rememberToken(token string, scopes []string) {
+	registryTokens.Lock()
+	defer registryTokens.Unlock()
+	registryTokens.byToken[token] = &registryToken{scopes: scopes, current: token, expires: tokenExpiry(token)}
+}
+
+// tokenExpiry returns the exp claim of the JWT, zero if it has none
+func tokenExpiry(token string) time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if json.Unmarshal(payload, &claims) != nil || claims.Exp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(claims.Exp, 0)
+}
+
+// currentToken returns the valid replacement of the token. The token is renewed if it
+// expires soon or if force is set and it wasn't renewed since it was used.
+func currentToken(token string, force bool) (string, bool, error) {
+	registryTokens.Lock()
+	defer registryTokens.Unlock()
+	t := registryTokens.byToken[token]
+	if t == nil {
+		return token, false, nil
+	}
+	expiring := !t.expires.IsZero() && time.Until(t.expires) < tokenRefreshMargin
+	if expiring || (force && t.current == token) {
+		renewed, err := fetchRegistryToken(t.scopes)
+		if err != nil {
+			return "", true, err
+		}
+		t.current = renewed
+		t.expires = tokenExpiry(t.current)
+		if err := cacheToken(t.scopes, t.current); err != nil {
+			return "", true, err
+		}
+		registryTokens.byToken[t.current] = t
+		if Cfg.Debug {
+			fmt.Fprintf(os.Stderr, "DEBUG renewed registry token for %s\n", strings.Join(t.scopes, " "))
+		}
+	}
+	return t.current, true, nil
+}
+
+// tokenTransport sends the current replacement of expired registry tokens and renews
+// the token and retries the request once if the registry rejects it with 401
+type tokenTransport struct {
+	next http.RoundTripper
+}
+
+func (t *tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if token == "" || token == req.Header.Get("Authorization") {
+		return t.next.RoundTrip(req)
+	}
+	current, known, err := currentToken(token, false)
+	if err != nil {
+		return nil, err
+	}
+	if !known {
+		return t.next.RoundTrip(req)
+	}
+	resp, err := t.next.RoundTrip(withToken(req, current))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || (req.Body != nil && req.GetBody == nil) {
+		return resp, err
+	}
+
+	// Renew the token and send the request again
+	resp.Body.Close()
+	renewed, _, err := currentToken(current, true)
+	if err != nil {
+		return nil, err
+	}
+	retry := withToken(req, renewed)
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	return t.next.RoundTrip(retry)
+}
+
+// withToken returns a copy of the request with the bearer token
+func withToken(req *http.Request, token string) *http.Request {
+	clone := req.Clone(req.Context())
+	clone.Header.Set("Authorization", "Bearer "+token)
+	return clone
+}
+
+// tokenCache holds the tokens of the -token-cache file by user and scopes
+var tokenCache struct {
+	tokens map[string]string
+	sync.Mutex
+}
+
+// tokenCacheKey identifies the tokens of a user for the scopes
+func tokenCacheKey(scopes []string) string {
+	sorted := append([]string{}, scopes...)
+	sort.Strings(sorted)
+	username, _ := registryCredentials()
+	return fmt.Sprintf("%s %s %s", Cfg.GitlabURL, username, strings.Join(sorted, " "))
+}
+
+// loadTokenCache reads the -token-cache file on first use, tokens expiring soon are dropped
+func loadTokenCache() error {
+	if tokenCache.tokens != nil {
+		return nil
+	}
+	data, err := ioutil.ReadFile(Cfg.TokenCache)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	tokenCache.tokens = make(map[string]string)
+	if err != nil {
+		return nil
+	}
+	var tokens map[string]string
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		fmt.Fprintf(os.Stderr, "Ignoring invalid token cache %s: %s\n", Cfg.TokenCache, err.Error())
+		return nil
+	}
+	for key, token := range tokens {
+		if expires := tokenExpiry(token); time.Until(expires) > tokenRefreshMargin {
+			tokenCache.tokens[key] = token
+		}
+	}
+	return nil
+}
+
+// cachedToken returns a valid token for the scopes from the -token-cache file
+func cachedToken(scopes []string) (string, error) {
+	if Cfg.TokenCache == "" {
+		return "", nil
+	}
+	tokenCache.Lock()
+	defer tokenCache.Unlock()
+	if err := loadTokenCache(); err != nil {
+		return "", err
+	}
+	token := tokenCache.tokens[tokenCacheKey(scopes)]
+	if time.Until(tokenExpiry(token)) <= tokenRefreshMargin {
+		return "", nil
+	}
+	return token, nil
+}
+
+// cacheToken stores the token in the -token-cache file. Only the owner can read the file,
+// it is replaced at once so concurrent runs don't read a partial file.
+func cacheToken(scopes []string, token string) error {
+	if Cfg.TokenCache == "" || tokenExpiry(token).IsZero() {
+		return nil
+	}
+	tokenCache.Lock()
+	defer tokenCache.Unlock()
+	if err := loadTokenCache(); err != nil {
+		return err
+	}
+	tokenCache.tokens[tokenCacheKey(scopes)] = token
+
+	data, err := json.MarshalIndent(tokenCache.tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(Cfg.TokenCache), filepath.Base(Cfg.TokenCache))
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), Cfg.TokenCache)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
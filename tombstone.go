@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// Tombstone records when an image was first found to be a deletion candidate
+type Tombstone struct {
+	Repository string    `json:"repository"`
+	Tag        string    `json:"tag"`
+	FirstSeen  time.Time `json:"first_seen"`
+}
+
+// filterTombstones records the candidates in the state file and removes those
+// which are candidates for less than the grace period from the slice
+func filterTombstones(images []*Image, file string, graceDays int) ([]*Image, error) {
+	// Read tombstones of previous runs
+	previous := make(map[string]time.Time)
+	data, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		var tombstones []Tombstone
+		if err := json.Unmarshal(data, &tombstones); err != nil {
+			return nil, err
+		}
+		for _, t := range tombstones {
+			previous[t.Repository+":"+t.Tag] = t.FirstSeen
+		}
+	}
+
+	// Images which are no candidate anymore lose their tombstone
+	now := time.Now()
+	deadline := now.AddDate(0, 0, graceDays*-1)
+	var tombstones []Tombstone
+	i := 0
+	for _, image := range images {
+		firstSeen, ok := previous[image.Name+":"+image.Tag]
+		if !ok {
+			firstSeen = now
+		}
+		tombstones = append(tombstones, Tombstone{Repository: image.Name, Tag: image.Tag, FirstSeen: firstSeen})
+
+		if firstSeen.After(deadline) {
+			// Print information
+			logf(lineKept, "Image %s:%s is marked for deletion since %s, skipped until the grace period is over\n", image.Name, image.Tag, firstSeen.Format(time.RFC3339))
+			image.Reason = "grace period"
+		} else {
+			// This image should stay in slice
+			images[i] = image
+			i++
+		}
+	}
+
+	// Write new state
+	data, err = json.MarshalIndent(tombstones, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(file, data, 0644); err != nil {
+		return nil, err
+	}
+	return images[:i], nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// span is a traced operation exported to an OpenTelemetry collector
+type span struct {
+	traceID    string
+	spanID     string
+	parent     *span
+	name       string
+	start      time.Time
+	end        time.Time
+	attributes map[string]string
+	failed     string
+}
+
+// Spans of the current run. Spans started with startSpan become the parent of
+// following spans until they end, which fits the sequential pruning steps.
+var tracer struct {
+	spans   []*span
+	current *span
+	sync.Mutex
+}
+
+// startSpan starts a span as child of the current span and makes it the current span.
+// Attributes are given as key value pairs. Returns nil if tracing is disabled.
+func startSpan(name string, attributes ...string) *span {
+	s := newSpan(name, attributes)
+	if s != nil {
+		tracer.Lock()
+		tracer.current = s
+		tracer.Unlock()
+	}
+	return s
+}
+
+// startDetachedSpan starts a span as child of the current span without making it the current span,
+// used by concurrent operations
+func startDetachedSpan(name string, attributes ...string) *span {
+	return newSpan(name, attributes)
+}
+
+func newSpan(name string, attributes []string) *span {
+	if Cfg.OTLPEndpoint == "" {
+		return nil
+	}
+	tracer.Lock()
+	defer tracer.Unlock()
+
+	s := &span{spanID: randomID(8), parent: tracer.current, name: name, start: time.Now(), attributes: make(map[string]string)}
+	if s.parent != nil {
+		s.traceID = s.parent.traceID
+	} else {
+		s.traceID = randomID(16)
+	}
+	for i := 0; i+1 < len(attributes); i += 2 {
+		s.attributes[attributes[i]] = attributes[i+1]
+	}
+	tracer.spans = append(tracer.spans, s)
+	return s
+}
+
+// set adds an attribute to the span
+func (s *span) set(key, value string) {
+	if s == nil {
+		return
+	}
+	tracer.Lock()
+	defer tracer.Unlock()
+	s.attributes[key] = value
+}
+
+// fail marks the span as failed
+func (s *span) fail(message string) {
+	if s == nil {
+		return
+	}
+	tracer.Lock()
+	defer tracer.Unlock()
+	s.failed = message
+}
+
+// finish ends the span and makes its parent the current span again
+func (s *span) finish() {
+	if s == nil {
+		return
+	}
+	tracer.Lock()
+	defer tracer.Unlock()
+	s.end = time.Now()
+	if tracer.current == s {
+		tracer.current = s.parent
+	}
+}
+
+func randomID(size int) string {
+	id := make([]byte, size)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(id)
+}
+
+// exportTraces ends the root span with the error the run failed with and sends all spans to the OTLP/HTTP endpoint
+func exportTraces(root *span, err error) {
+	if root == nil {
+		return
+	}
+	if err != nil {
+		root.fail(err.Error())
+	}
+	root.finish()
+
+	// Convert spans to the OTLP JSON encoding
+	tracer.Lock()
+	var spans []map[string]interface{}
+	for _, s := range tracer.spans {
+		if s.end.IsZero() {
+			s.end = time.Now()
+		}
+		var attributes []map[string]interface{}
+		for key, value := range s.attributes {
+			attributes = append(attributes, map[string]interface{}{"key": key, "value": map[string]string{"stringValue": value}})
+		}
+		otlpSpan := map[string]interface{}{
+			"traceId":           s.traceID,
+			"spanId":            s.spanID,
+			"name":              s.name,
+			"kind":              1,
+			"startTimeUnixNano": fmt.Sprint(s.start.UnixNano()),
+			"endTimeUnixNano":   fmt.Sprint(s.end.UnixNano()),
+			"attributes":        attributes,
+		}
+		if s.parent != nil {
+			otlpSpan["parentSpanId"] = s.parent.spanID
+		}
+		if s.failed != "" {
+			otlpSpan["status"] = map[string]interface{}{"code": 2, "message": s.failed}
+		}
+		spans = append(spans, otlpSpan)
+	}
+	tracer.spans = nil
+	tracer.current = nil
+	tracer.Unlock()
+
+	payload := map[string]interface{}{
+		"resourceSpans": []map[string]interface{}{{
+			"resource": map[string]interface{}{
+				"attributes": []map[string]interface{}{
+					{"key": "service.name", "value": map[string]string{"stringValue": "gitlab-registry-pruner"}},
+				},
+			},
+			"scopeSpans": []map[string]interface{}{{
+				"scope": map[string]string{"name": "gitlab-registry-pruner"},
+				"spans": spans,
+			}},
+		}},
+	}
+	sendNotification("OTLP", func() error {
+		return postJSON(strings.TrimRight(Cfg.OTLPEndpoint, "/")+"/v1/traces", payload)
+	})
+}
+
+// tracingTransport creates a span for every request
+type tracingTransport struct {
+	next http.RoundTripper
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Don't trace the export itself
+	if strings.HasPrefix(req.URL.String(), Cfg.OTLPEndpoint) {
+		return t.next.RoundTrip(req)
+	}
+
+	s := startDetachedSpan("HTTP "+req.Method, "http.method", req.Method, "http.url", req.URL.Redacted(), "net.peer.name", req.URL.Host)
+	defer s.finish()
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		s.fail(err.Error())
+		return resp, err
+	}
+	s.set("http.status_code", fmt.Sprint(resp.StatusCode))
+	return resp, nil
+}
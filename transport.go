@@ -0,0 +1,445 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// debugBodyLimit is the number of response body bytes logged with -debug
+const debugBodyLimit = 512
+
+// debugSecretHeaders are logged as [REDACTED] with -debug
+var debugSecretHeaders = map[string]bool{"Authorization": true, "Private-Token": true, "Job-Token": true, "Cookie": true, "Set-Cookie": true, "X-Vault-Token": true}
+
+// debugSecretFields matches token fields of JSON bodies
+var debugSecretFields = regexp.MustCompile(`"(token|access_token|refresh_token|client_token|id_token|password|secret_id)"\s*:\s*"[^"]*"?`)
+
+// httpClient is shared by all registry and GitLab requests so connections are reused
+var httpClient = &http.Client{}
+
+// initHTTPClient creates the shared http client from the transport settings
+func initHTTPClient() error {
+	tlsClientConfig, err := tlsConfig()
+	if err != nil {
+		return err
+	}
+	transport := &http.Transport{
+		Proxy: proxyURL,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          Cfg.MaxIdleConns,
+		MaxIdleConnsPerHost:   Cfg.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       Cfg.MaxConnsPerHost,
+		IdleConnTimeout:       Cfg.IdleConnTimeout,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		ForceAttemptHTTP2:     Cfg.HTTP2,
+		DisableKeepAlives:     !Cfg.KeepAlive,
+		TLSClientConfig:       tlsClientConfig,
+	}
+
+	// A non-nil empty map disables HTTP/2
+	if !Cfg.HTTP2 {
+		transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+
+	httpClient = &http.Client{Transport: &timeoutTransport{transport}}
+	if Cfg.HTTPCache != "" {
+		if err := os.MkdirAll(Cfg.HTTPCache, 0700); err != nil {
+			return err
+		}
+		httpClient.Transport = &etagTransport{Cfg.HTTPCache, httpClient.Transport}
+	}
+	if Cfg.Replay != "" {
+		httpClient.Transport = &replayTransport{Cfg.Replay}
+	} else if Cfg.Record != "" {
+		if err := os.MkdirAll(Cfg.Record, 0700); err != nil {
+			return err
+		}
+		httpClient.Transport = &recordTransport{Cfg.Record, httpClient.Transport}
+	}
+	if Cfg.Debug {
+		httpClient.Transport = &debugTransport{httpClient.Transport}
+	}
+	if Cfg.OTLPEndpoint != "" {
+		httpClient.Transport = &tracingTransport{httpClient.Transport}
+	}
+	if (Cfg.Retries > 0 || Cfg.RateLimitRetries > 0) && Cfg.Replay == "" {
+		httpClient.Transport = &retryTransport{httpClient.Transport}
+	}
+	if Cfg.Replay == "" {
+		httpClient.Transport = &tokenTransport{httpClient.Transport}
+	}
+	return nil
+}
+
+// proxyURL returns the proxy of the request: the proxy of its endpoint, -proxy or the one of the environment
+func proxyURL(req *http.Request) (*url.URL, error) {
+	proxy := Cfg.Proxy
+	switch {
+	case Cfg.RegistryProxy != "" && sameHost(req.URL, Cfg.RegistryURL):
+		proxy = Cfg.RegistryProxy
+	case Cfg.GitlabProxy != "" && sameHost(req.URL, Cfg.GitlabURL):
+		proxy = Cfg.GitlabProxy
+	}
+	if proxy == "" {
+		return http.ProxyFromEnvironment(req)
+	}
+	return url.Parse(proxy)
+}
+
+// sameHost returns true if the URL points to the host of the endpoint
+func sameHost(u *url.URL, endpoint string) bool {
+	e, err := url.Parse(endpoint)
+	return err == nil && e.Host == u.Host
+}
+
+// checkProxies returns an error if one of the proxy flags is no valid proxy URL
+func checkProxies() error {
+	for name, proxy := range map[string]string{"proxy": Cfg.Proxy, "registry-proxy": Cfg.RegistryProxy, "gitlab-proxy": Cfg.GitlabProxy} {
+		if proxy == "" {
+			continue
+		}
+		u, err := url.Parse(proxy)
+		if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5") {
+			return fmt.Errorf("invalid -%s %q, expected http://, https:// or socks5:// with host and port", name, proxy)
+		}
+	}
+	return nil
+}
+
+// checkEndpoints adds the missing scheme to the GitLab and registry URLs and warns about insecure connections
+func checkEndpoints() error {
+	Cfg.GitlabURL = endpointURL(Cfg.GitlabURL)
+	Cfg.RegistryURL = endpointURL(Cfg.RegistryURL)
+	if err := checkProxies(); err != nil {
+		return err
+	}
+
+	warn := func(text string) {
+		fmt.Fprintln(os.Stderr, colorize(colorBoldRed, "WARNING: "+text))
+	}
+	if Cfg.InsecureSkipTLSVerify {
+		warn("-insecure-skip-tls-verify is set, the certificates of GitLab and the registry are not verified and connections can be intercepted")
+	}
+	for i, endpoint := range []string{Cfg.GitlabURL, Cfg.RegistryURL} {
+		if strings.HasPrefix(endpoint, "http://") && (i == 0 || endpoint != Cfg.GitlabURL) {
+			warn(endpoint + " uses plain HTTP, passwords and tokens are sent unencrypted")
+		}
+	}
+	return nil
+}
+
+// endpointURL returns the URL with https as default scheme and without trailing slash
+func endpointURL(endpoint string) string {
+	if endpoint == "" {
+		return ""
+	}
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+	return strings.TrimRight(endpoint, "/")
+}
+
+// tlsConfig returns the TLS settings of the GitLab and registry connections
+func tlsConfig() (*tls.Config, error) {
+	version, err := tlsVersion(Cfg.TLSMinVersion)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{MinVersion: version, InsecureSkipVerify: Cfg.InsecureSkipTLSVerify}
+
+	// Trust the private CA in addition to the system CAs
+	if Cfg.CACert != "" {
+		pem, err := ioutil.ReadFile(Cfg.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read -ca-cert: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("-ca-cert %s contains no PEM certificate", Cfg.CACert)
+		}
+		config.RootCAs = pool
+	}
+
+	// Authenticate with the client certificate at endpoints requiring mutual TLS
+	if Cfg.ClientCert != "" || Cfg.ClientKey != "" {
+		if Cfg.ClientCert == "" || Cfg.ClientKey == "" {
+			return nil, errors.New("-client-cert and -client-key must be given together")
+		}
+		cert, err := tls.LoadX509KeyPair(Cfg.ClientCert, Cfg.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// tlsVersion returns the TLS version constant of the version number
+func tlsVersion(version string) (uint16, error) {
+	switch version {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unknown TLS version %q", version)
+}
+
+// requestTimeout returns the timeout of the request depending on what it does
+func requestTimeout(req *http.Request) time.Duration {
+	var timeout time.Duration
+	switch {
+	case req.Context().Value(throttledKey{}) != nil:
+		// Throttled blob copies take as long as -bandwidth-limit needs
+		return 0
+	case strings.HasSuffix(req.URL.Path, "/jwt/auth"):
+		timeout = Cfg.TokenTimeout
+	case req.Method == "DELETE":
+		timeout = Cfg.DeleteTimeout
+	case strings.HasSuffix(req.URL.Path, "/tags/list"):
+		timeout = Cfg.TagListTimeout
+	case strings.Contains(req.URL.Path, "/manifests/"):
+		timeout = Cfg.ManifestTimeout
+	}
+	if timeout == 0 {
+		timeout = Cfg.HTTPTimeout
+	}
+	return timeout
+}
+
+// timeoutTransport aborts requests, including reading the response body, after their timeout.
+// A hung registry connection would otherwise block the run forever.
+type timeoutTransport struct {
+	next http.RoundTripper
+}
+
+func (t *timeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	timeout := requestTimeout(req)
+	if timeout <= 0 {
+		return t.next.RoundTrip(req)
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	resp, err := t.next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("no response within %s: %w", timeout, context.DeadlineExceeded)
+		}
+		return nil, err
+	}
+
+	// The timeout also applies to reading the body, cancel once it is closed
+	resp.Body = &cancelBody{resp.Body, cancel}
+	return resp, nil
+}
+
+// cancelBody cancels the context of its request when closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// retryTransport retries idempotent requests failing with a network error or 5xx response
+// with exponential backoff. A single blip would otherwise abort a long run. Other requests,
+// e.g. creating an issue, are only retried if rate limited as they might have been processed.
+type retryTransport struct {
+	next http.RoundTripper
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil && req.GetBody == nil {
+		return t.next.RoundTrip(req)
+	}
+	idempotent := req.Method == "GET" || req.Method == "HEAD" || req.Method == "PUT" || req.Method == "DELETE"
+
+	backoff := Cfg.RetryBackoff
+	var retries, rateLimited int
+	for attempt := 0; ; attempt++ {
+		// Send a fresh copy of the body with every attempt
+		attemptReq := req
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			attemptReq = req.Clone(req.Context())
+			attemptReq.Body = body
+		}
+		resp, err := t.next.RoundTrip(attemptReq)
+		if req.Context().Err() != nil {
+			return resp, err
+		}
+
+		// Rate limited requests were not processed, wait as long as the server asks
+		var wait time.Duration
+		var count *int
+		var limit int
+		switch {
+		case err == nil && resp.StatusCode == http.StatusTooManyRequests:
+			count, limit = &rateLimited, Cfg.RateLimitRetries
+			wait = retryAfter(resp, jitter(backoff))
+		case idempotent && retryable(resp, err):
+			count, limit = &retries, Cfg.Retries
+			wait = jitter(backoff)
+			if err == nil {
+				wait = retryAfter(resp, wait)
+			}
+		default:
+			return resp, err
+		}
+		if *count >= limit {
+			return resp, err
+		}
+		*count++
+
+		// Discard the failed response
+		failure := fmt.Sprint(err)
+		if err == nil {
+			failure = resp.Status
+			io.Copy(ioutil.Discard, io.LimitReader(resp.Body, debugBodyLimit))
+			resp.Body.Close()
+		}
+
+		fmt.Fprintf(os.Stderr, "Retrying %s %s in %s after %s (%d/%d)\n", req.Method, req.URL.Redacted(), wait.Round(time.Millisecond), failure, *count, limit)
+		select {
+		case <-time.After(wait):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+		if backoff *= 2; backoff > Cfg.RetryMaxBackoff {
+			backoff = Cfg.RetryMaxBackoff
+		}
+	}
+}
+
+// retryable returns whether the request failed with a network error or a server error
+// which might not happen again
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		// Connection errors and timeouts, but not invalid requests
+		var netErr net.Error
+		return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+	}
+	return resp.StatusCode >= 500 && resp.StatusCode != http.StatusNotImplemented
+}
+
+// retryAfter returns the wait of the Retry-After header given in seconds or as date,
+// or the default if there is none
+func retryAfter(resp *http.Response, def time.Duration) time.Duration {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return def
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait
+		}
+		return 0
+	}
+	return def
+}
+
+// jitter varies the duration randomly by -retry-jitter so clients don't retry at the same time
+func jitter(d time.Duration) time.Duration {
+	return time.Duration(float64(d) * (1 + Cfg.RetryJitter*(2*rand.Float64()-1)))
+}
+
+// debugTransport logs every request with status, latency and the beginning of the response body
+type debugTransport struct {
+	next http.RoundTripper
+}
+
+func (d *debugTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := d.next.RoundTrip(req)
+	latency := time.Since(start).Round(time.Millisecond)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "DEBUG %s %s failed after %s: %s\n", req.Method, debugURL(req.URL), latency, err.Error())
+		return resp, err
+	}
+
+	// Read the beginning of the body and put it back in front of the rest
+	head, _ := ioutil.ReadAll(io.LimitReader(resp.Body, debugBodyLimit))
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(head), resp.Body), resp.Body}
+
+	fmt.Fprintf(os.Stderr, "DEBUG %s %s %d %s %q\n", req.Method, debugURL(req.URL), resp.StatusCode, latency, debugBody(req, head))
+	fmt.Fprintf(os.Stderr, "DEBUG   request headers: %s\n", debugHeaders(req.Header))
+	fmt.Fprintf(os.Stderr, "DEBUG   response headers: %s\n", debugHeaders(resp.Header))
+	return resp, nil
+}
+
+// debugURL returns the URL without credentials. The path of notification webhooks is left out, it contains their secret.
+func debugURL(u *url.URL) string {
+	for _, webhook := range []string{Cfg.SlackWebhook, Cfg.TeamsWebhook, Cfg.NotifyURL, Cfg.GCWebhook} {
+		w, err := url.Parse(webhook)
+		if webhook != "" && err == nil && w.Host == u.Host && w.Path == u.Path {
+			return u.Scheme + "://" + u.Host + "/[REDACTED]"
+		}
+	}
+	return u.Redacted()
+}
+
+// debugBody returns the body without tokens. Bodies of the token endpoints are left out completely.
+func debugBody(req *http.Request, body []byte) string {
+	if strings.HasSuffix(req.URL.Path, "/jwt/auth") || strings.HasSuffix(req.URL.Path, "/oauth/token") || strings.Contains(req.URL.Path, "/auth/") {
+		return "[REDACTED]"
+	}
+	return debugSecretFields.ReplaceAllString(string(body), `"$1":"[REDACTED]"`)
+}
+
+// debugHeaders returns the headers sorted by name with credentials and cookies redacted
+func debugHeaders(header http.Header) string {
+	var names []string
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var parts []string
+	for _, name := range names {
+		value := strings.Join(header[name], ", ")
+		if debugSecretHeaders[http.CanonicalHeaderKey(name)] {
+			value = "[REDACTED]"
+		}
+		parts = append(parts, name+": "+value)
+	}
+	return strings.Join(parts, "; ")
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	trashSuffix     = "/trash"
+	trashTagPrefix  = "trashed-"
+	trashDateFormat = "20060102"
+)
+
+// trashTagPattern matches tags of trashed images and captures the trash date and original tag
+var trashTagPattern = regexp.MustCompile(`^` + trashTagPrefix + `([0-9]{8})-(.+)$`)
+
+// trashImages copies the images to the trash repository next to their repository
+// before they are deleted. The trash date is part of the new tag.
+func trashImages(images []*Image) error {
+	prefix := trashTagPrefix + time.Now().Format(trashDateFormat) + "-"
+	tokens := make(map[*Repository]string)
+	for _, image := range images {
+		// Images in a trash repository are deleted for good
+		if strings.HasSuffix(image.Name, trashSuffix) {
+			continue
+		}
+
+		// Get token which can push to the trash repository
+		token, ok := tokens[image.Repository]
+		if !ok {
+			var err error
+			token, err = getRegistryTokenForScopes(
+				fmt.Sprintf("repository:%s:pull", image.Name),
+				fmt.Sprintf("repository:%s:pull,push", image.Name+trashSuffix))
+			if err != nil {
+				return withContext(err, image.Name, image.Tag)
+			}
+			tokens[image.Repository] = token
+		}
+
+		// Copy image
+		if err := copyManifest(token, image.Name, image.Digest, image.Name+trashSuffix, prefix+image.Tag); err != nil {
+			return withContext(fmt.Errorf("cannot move %s:%s to the trash: %w", image.Name, image.Tag, err), image.Name, image.Tag)
+		}
+		logf(lineDeleted, "Image moved to trash: %s:%s -> %s:%s\n", image.Name, image.Tag, image.Name+trashSuffix, prefix+image.Tag)
+	}
+	return nil
+}
+
+// purgeTrash deletes images from the trash repositories which were trashed more than the given days ago
+func purgeTrash(args []string) error {
+	fs := flag.NewFlagSet("purge-trash", flag.ContinueOnError)
+	days := fs.Int("days", 30, "Delete images which are in the trash for more than this number of days")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: gitlab-registry-pruner [flags] purge-trash [-days n]")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+
+	// --- Find expired images in the trash repositories ---
+	var images []*Image
+	deadline := time.Now().AddDate(0, 0, *days*-1)
+	paths, err := expandRepositories(Cfg.Repositories)
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if !strings.HasSuffix(path, trashSuffix) {
+			path += trashSuffix
+		}
+		repo := newRepository(path)
+		if repo.Token, err = getRegistryToken(repo); err != nil {
+			return withContext(err, repo.Name, "")
+		}
+		repoImages, err := getImages(repo)
+		if err != nil {
+			return withContext(err, repo.Name, "")
+		}
+		for _, image := range repoImages {
+			match := trashTagPattern.FindStringSubmatch(image.Tag)
+			if match == nil {
+				continue
+			}
+			trashed, err := time.Parse(trashDateFormat, match[1])
+			if err != nil || !trashed.Before(deadline) {
+				continue
+			}
+			images = append(images, image)
+		}
+	}
+
+	// --- Print resulting images ---
+	for _, image := range images {
+		logf(lineDeleted, "%sImage will be deleted: %s:%s\n", dryRunPrefix(), image.Name, image.Tag)
+	}
+	printDryRunNotice()
+
+	// --- Give the user the chance to think about it ---
+	if Cfg.DeleteImages && len(images) > 0 {
+		if !Cfg.Yes {
+			if ok, err := confirmDeletion(); !ok {
+				return err
+			}
+		}
+
+		// Start delete process
+		fmt.Println("--- Starting delete process ---")
+		if err := resolveDigests(images); err != nil {
+			return err
+		}
+		return deleteImages(images)
+	}
+	return nil
+}
+
+// untrashedTag returns the original tag of a trashed image
+func untrashedTag(tag string) string {
+	if match := trashTagPattern.FindStringSubmatch(tag); match != nil {
+		return match[2]
+	}
+	return tag
+}
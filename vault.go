@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// useVault takes the credentials missing on the command line from the fields username, password and
+// token of the -vault-path secret. KV version 1 and 2 secrets are supported.
+func useVault() error {
+	if Cfg.VaultAddr == "" {
+		Cfg.VaultAddr = os.Getenv("VAULT_ADDR")
+	}
+	if Cfg.VaultAddr == "" {
+		return errors.New("-vault-path needs -vault-addr or VAULT_ADDR")
+	}
+	Cfg.VaultAddr = endpointURL(Cfg.VaultAddr)
+	tlsClientConfig, err := tlsConfig()
+	if err != nil {
+		return err
+	}
+	client := &http.Client{
+		Timeout:   Cfg.HTTPTimeout,
+		Transport: &http.Transport{Proxy: proxyURL, TLSClientConfig: tlsClientConfig},
+	}
+
+	// Log in with AppRole unless a token is given
+	token := Cfg.VaultToken
+	if token == "" {
+		token = os.Getenv("VAULT_TOKEN")
+	}
+	if token == "" {
+		roleID, secretID := Cfg.VaultRoleID, os.Getenv("VAULT_SECRET_ID")
+		if roleID == "" {
+			roleID = os.Getenv("VAULT_ROLE_ID")
+		}
+		if roleID == "" || secretID == "" {
+			return errors.New("-vault-path needs VAULT_TOKEN or -vault-role-id and VAULT_SECRET_ID")
+		}
+		var login struct {
+			Auth struct {
+				ClientToken string `json:"client_token"`
+			} `json:"auth"`
+		}
+		payload, _ := json.Marshal(map[string]string{"role_id": roleID, "secret_id": secretID})
+		if err := vaultRequest(client, "POST", "auth/approle/login", "", payload, &login); err != nil {
+			return err
+		}
+		token = login.Auth.ClientToken
+	}
+
+	// Read secret, KV version 2 nests the fields in data.data
+	var secret struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := vaultRequest(client, "GET", Cfg.VaultPath, token, nil, &secret); err != nil {
+		return err
+	}
+	fields := secret.Data
+	if nested, ok := fields["data"].(map[string]interface{}); ok && fields["metadata"] != nil {
+		fields = nested
+	}
+
+	// Credentials given as flags take precedence
+	set := func(value *string, field string) {
+		if s, ok := fields[field].(string); ok && *value == "" {
+			*value = s
+		}
+	}
+	set(&Cfg.Username, "username")
+	set(&Cfg.Password, "password")
+	set(&Cfg.Token, "token")
+	if Cfg.Password == "" && Cfg.Token == "" {
+		return fmt.Errorf("Vault secret %s has neither a password nor a token field", Cfg.VaultPath)
+	}
+	return nil
+}
+
+// vaultRequest sends the request to the Vault API and decodes the response into result
+func vaultRequest(client *http.Client, method, path, token string, payload []byte, result interface{}) error {
+	req, err := http.NewRequest(method, Cfg.VaultAddr+"/v1/"+strings.TrimPrefix(path, "/"), bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if namespace := os.Getenv("VAULT_NAMESPACE"); namespace != "" {
+		req.Header.Set("X-Vault-Namespace", namespace)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("cannot reach Vault: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		// The hints of newResponseError are about GitLab
+		return fmt.Errorf("cannot read Vault %s, return code %d: %s", path, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	if err := json.Unmarshal(body, result); err != nil {
+		return fmt.Errorf("invalid Vault response of %s: %w", path, err)
+	}
+	return nil
+}
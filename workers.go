@@ -0,0 +1,65 @@
+package main
+
+import "sync"
+
+// forEach calls fn for the indexes 0 to n-1 with up to the given number of goroutines.
+// The first error of fn stops the remaining calls and is returned once the running
+// calls have returned. A panic of fn is passed on to the caller as a last resort.
+func forEach(workers, n int, fn func(i int) error) error {
+	if workers > n {
+		workers = n
+	}
+	if workers <= 1 {
+		for i := 0; i < n; i++ {
+			if err := fn(i); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	var failure error
+	var crash interface{}
+	next := 0
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					capturePanic()
+					mutex.Lock()
+					if crash == nil {
+						crash = r
+					}
+					mutex.Unlock()
+				}
+			}()
+			for {
+				// Take the next index unless a call failed
+				mutex.Lock()
+				i := next
+				next++
+				stop := failure != nil || crash != nil
+				mutex.Unlock()
+				if stop || i >= n {
+					return
+				}
+				if err := fn(i); err != nil {
+					mutex.Lock()
+					if failure == nil {
+						failure = err
+					}
+					mutex.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if crash != nil {
+		panic(crash)
+	}
+	return failure
+}